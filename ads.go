@@ -2,6 +2,7 @@ package hls
 
 import (
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/as/hls/m3u"
@@ -18,6 +19,11 @@ type AD struct {
 	SCTE35OatclsSplice string    `hls:"EXT-OATCLS-SCTE35,noquote,omitempty" json:",omitempty"`
 }
 
+// IsZero returns true if no tags are set
+func (f AD) IsZero() bool {
+	return reflect.ValueOf(f).IsZero()
+}
+
 // IsAD returns true if the segment looks like an AD-break. This currently only handles
 // the three standard EXT-X-CUE-OUT, EXT-X-CUE-OUT-CONT, and EXT-X-CUE-IN
 // tags. Examine the SCTE35 fields manually to handle other formats
@@ -32,7 +38,7 @@ func (f *AD) IsAD() bool {
 // The SCTE35 field is set to the OatcltSplice or SCTE35Splice field in the File
 // if not set in the Cue natively. This can be in binary, hex, or base64 format.
 //
-// Use: github.com/as/scte35.Parse(...) to decode the bitstream
+// Use: github.com/as/hls/scte35.Parse(...) to decode the bitstream
 //
 // Example:
 //
@@ -117,15 +123,26 @@ func (c SCTE35) IsAD() bool {
 type DateRange struct {
 	ID       string        `hls:"ID,omitempty" json:",omitempty"`
 	Class    string        `hls:"CLASS,omitempty" json:",omitempty"`
-	Start    time.Time     `hls:"START-DATE,omitempty" json:",omitempty"`
+	Start    time.Time     `hls:"START-DATE,quote,omitempty" json:",omitempty"`
 	Cue      string        `hls:"CUE,omitempty" json:",omitempty"`
-	End      time.Time     `hls:"END-DATE,omitempty" json:",omitempty"`
-	Duration time.Duration `hls:"DURATION" json:",omitempty"`
-	Planned  time.Duration `hls:"PLANNED-DURATION" json:",omitempty"`
+	End      time.Time     `hls:"END-DATE,quote,omitempty" json:",omitempty"`
+	Duration time.Duration `hls:"DURATION,omitempty" json:",omitempty"`
+	Planned  time.Duration `hls:"PLANNED-DURATION,omitempty" json:",omitempty"`
 	CueIn    string        `hls:"SCTE35-IN,noquote,omitempty" json:",omitempty"`
 	CueOut   string        `hls:"SCTE35-OUT,noquote,omitempty" json:",omitempty"`
 	Cmd      string        `hls:"SCTE35-CMD,noquote,omitempty" json:",omitempty"`
 	EndNext  bool          `hls:"END-ON-NEXT,omitempty" json:",omitempty"`
+
+	// HLS Interstitials, see interstitial.go
+	AssetURI     string         `hls:"X-ASSET-URI,omitempty" json:",omitempty"`
+	AssetList    string         `hls:"X-ASSET-LIST,omitempty" json:",omitempty"`
+	ResumeOffset *time.Duration `hls:"X-RESUME-OFFSET,omitempty" json:",omitempty"`
+	PlayoutLimit time.Duration  `hls:"X-PLAYOUT-LIMIT,omitempty" json:",omitempty"`
+	Snap         string         `hls:"X-SNAP,omitempty" json:",omitempty"`     // comma-separated, like Cue
+	Restrict     string         `hls:"X-RESTRICT,omitempty" json:",omitempty"` // comma-separated, like Cue
+
+	// Client holds the other X- attributes in order, see Attr
+	Client Attrs `hls:"*,omitempty" json:",omitempty"`
 }
 
 // IsAD returns true if the cue is a cue-in or cue-out point
@@ -133,6 +150,11 @@ func (c DateRange) IsAD() bool {
 	return c.CueIn != "" || c.CueOut != ""
 }
 
+// IsZero returns true if no attributes are set
+func (c DateRange) IsZero() bool {
+	return reflect.ValueOf(c).IsZero()
+}
+
 // Cue is used by EXT-X-CUE-IN / EXT-X-CUE-OUT pairs
 // the ID field is supported by Google Ad Manager for CUE-OUTs
 type Cue struct {
@@ -0,0 +1,100 @@
+package hls
+
+import "time"
+
+// Break is an ad break in a content playlist. The Splicer only sets
+// ID, Seq and Duration, the rest is set by Media.Breaks.
+type Break struct {
+	ID       string        // BREAKID of the cue, if any
+	Seq      int           // media sequence number of the first content segment in the break
+	Duration time.Duration // planned duration, zero if the cue has no duration
+
+	Format CueFormat
+	Index  int           // index of the first segment of the break in Media.File
+	Len    int           // number of segments in the break
+	Actual time.Duration // time from the start of the break to the end of its last segment
+	Closed bool          // the break ended in the playlist
+}
+
+// Breaks groups the segments of m into ad breaks, from the cue-out to the
+// cue-in, using the cues of any format recognized by Signals. A break that
+// started before the first segment in the playlist is included, and its
+// actual duration counts the elapsed time in its first cue.
+//
+// The findings report overlapping breaks, cue-ins outside of a break,
+// CUE-OUT-CONT elapsed times that don't match the segment durations,
+// breaks that are still open at the end of a VOD playlist, and breaks
+// whose actual duration differs from the planned one by more than a
+// target duration. The index of a finding refers to m.File.
+func (m Media) Breaks() ([]Break, []Finding) {
+	var (
+		list []Break
+		fs   findings
+		cur  *Break
+	)
+	for i, s := range m.Signals() {
+		f := m.File[i]
+		switch s.Kind {
+		case "out":
+			if cur != nil {
+				fs.add(Should, "break-overlap", "", cuetag(s.Format, "out"), i, "break %q starts before break %q ended", s.ID, cur.ID)
+			}
+			list = append(list, Break{ID: s.ID, Seq: m.Seq(i), Duration: s.Duration, Format: s.Format, Index: i})
+			cur = &list[len(list)-1]
+		case "cont":
+			if cur == nil {
+				list = append(list, Break{ID: s.ID, Seq: m.Seq(i), Duration: s.Duration, Format: s.Format, Index: i, Actual: s.Elapsed})
+				cur = &list[len(list)-1]
+			}
+			if raw, ok := f.AD.Signal(); ok && raw.Kind == "cont" && raw.Elapsed != 0 {
+				if d := raw.Elapsed - cur.Actual; d > time.Second/2 || d < -time.Second/2 {
+					fs.add(Should, "break-elapsed", "", cuetag(raw.Format, "cont"), i, "elapsed time %s, but the break has lasted %s", raw.Elapsed, cur.Actual)
+				}
+			}
+		case "in":
+			if cur == nil {
+				fs.add(Should, "break-stray-in", "", cuetag(s.Format, "in"), i, "cue-in outside of a break")
+				continue
+			}
+			cur.Closed = true
+			m.checkbreak(&fs, *cur)
+			cur = nil
+		}
+		if cur != nil {
+			cur.Len++
+			cur.Actual += f.Duration(m.Target)
+		}
+	}
+	if cur != nil && m.End {
+		fs.add(Should, "break-unterminated", "", cuetag(cur.Format, "out"), cur.Index, "break %q doesn't end before EXT-X-ENDLIST", cur.ID)
+	}
+	return list, fs
+}
+
+func (m Media) checkbreak(fs *findings, b Break) {
+	if b.Duration == 0 {
+		return
+	}
+	if d := b.Actual - b.Duration; d > m.Target || d < -m.Target {
+		fs.add(Should, "break-duration", "", cuetag(b.Format, "out"), b.Index, "break %q lasted %s, but %s was planned", b.ID, b.Actual, b.Duration)
+	}
+}
+
+// cuetag returns the name of the tag that signals the cue
+func cuetag(format CueFormat, kind string) string {
+	switch format {
+	case FormatDateRange:
+		return "EXT-X-DATERANGE"
+	case FormatSCTE35:
+		return "EXT-X-SCTE35"
+	case FormatAdobe:
+		return "EXT-X-CUE"
+	}
+	switch kind {
+	case "cont":
+		return "EXT-X-CUE-OUT-CONT"
+	case "in":
+		return "EXT-X-CUE-IN"
+	}
+	return "EXT-X-CUE-OUT"
+}
@@ -6,17 +6,19 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/as/hls/m3u"
 )
 
-// symtab is the symbol table
-// currently, this is not safe for concurrent use unless all symbols are
-// loaded at init time and are immutable. the encoding/json package
-// uses a sync.Map, but we don't need to do that because we have control
-// over all the types we see in this package.
-var symtab = map[reflect.Type]sym{}
+// symtab is the symbol table. it's guarded by symmu, so playlists
+// can be decoded concurrently. two goroutines may both compile the
+// same type, but the results are identical, so the last one wins.
+var (
+	symtab = map[reflect.Type]sym{}
+	symmu  sync.RWMutex
+)
 
 type sfield struct {
 	// index of this field in the parent struct
@@ -30,7 +32,7 @@ type sfield struct {
 	// set knows how to set the value to the contents
 	// of the tag. The final argument is an option key-value
 	// used for attribute names
-	set func(reflect.Value, m3u.Tag, string)
+	set func(reflect.Value, m3u.Tag, string) error
 
 	// TODO(as): implement: should product a tag from a
 	// reflect.Value, for marshalling
@@ -54,7 +56,9 @@ func RegisterTag(name string, value interface{}) {
 	new := func() reflect.Value {
 		return reflect.Indirect(reflect.New(t))
 	}
+	symmu.Lock()
 	extratag[name] = new
+	symmu.Unlock()
 }
 
 // register registers the reflect.Value as a symbol exactly once
@@ -62,7 +66,9 @@ func RegisterTag(name string, value interface{}) {
 // types recognized by the package
 func register(v reflect.Value, attr bool) sym {
 	t := v.Type()
+	symmu.RLock()
 	s, ok := symtab[t]
+	symmu.RUnlock()
 	if ok {
 		return s
 	}
@@ -72,7 +78,9 @@ func register(v reflect.Value, attr bool) sym {
 	switch t.Kind() {
 	case reflect.Ptr:
 		s := register(v.Elem(), attr)
+		symmu.Lock()
 		symtab[t] = s
+		symmu.Unlock()
 		return s
 	case reflect.Slice:
 	case reflect.Struct:
@@ -100,7 +108,9 @@ func register(v reflect.Value, attr bool) sym {
 	default:
 		return sym{}
 	}
+	symmu.Lock()
 	symtab[t] = s
+	symmu.Unlock()
 	return s
 }
 
@@ -183,6 +193,14 @@ func settag(rf reflect.Value, t *m3u.Tag, quote bool) {
 			}
 			for _, label := range sym.names {
 				sf := sym.field[label.name]
+				if label.name == "*" {
+					extra, _ := rf.Field(sf.index).Interface().(Attrs)
+					for _, c := range extra {
+						t.Keys = append(t.Keys, c.Name)
+						t.Flag[c.Name] = c.value()
+					}
+					continue
+				}
 				attr := tostring(rf.Field(sf.index), label.omitempty)
 				if attr == "" {
 					continue
@@ -208,6 +226,12 @@ func tostring(rf reflect.Value, omitempty bool) string {
 	if omitempty && rf.IsZero() {
 		return ""
 	}
+	if rf.Kind() == reflect.Ptr {
+		if rf.IsNil() {
+			return ""
+		}
+		rf = rf.Elem()
+	}
 	switch t := rf.Interface().(type) {
 	case bool:
 		if t {
@@ -229,30 +253,33 @@ func tostring(rf reflect.Value, omitempty bool) string {
 }
 
 // compileDec returns a func that can decode the m3u.Tag into the
-// type represented by the input argument rf
-func compileDec(rf reflect.Value) func(reflect.Value, m3u.Tag, string) {
+// type represented by the input argument rf. The func returns a
+// *ValueError if the value is malformed, but always sets the field.
+func compileDec(rf reflect.Value) func(reflect.Value, m3u.Tag, string) error {
 	type tagdecoder interface {
 		decodetag(t m3u.Tag)
 	}
 	if rf.CanAddr() {
 		switch rf.Addr().Interface().(type) {
 		case tagdecoder:
-			return func(rf reflect.Value, t m3u.Tag, key string) {
+			return func(rf reflect.Value, t m3u.Tag, key string) error {
 				td, _ := rf.Addr().Interface().(tagdecoder)
 				if td != nil {
 					td.decodetag(t)
 				}
+				return nil
 			}
 		}
 	}
 
 	switch rf.Interface().(type) {
 	case m3u.Tag:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
 			rf.Set(reflect.ValueOf(t))
+			return nil
 		}
 	case bool:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
 			if key == "" {
 				rf.SetBool(true)
 			} else {
@@ -261,58 +288,100 @@ func compileDec(rf reflect.Value) func(reflect.Value, m3u.Tag, string) {
 					rf.SetBool(true)
 				}
 			}
+			return nil
 		}
 	case float32, float64:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
-			f, _ := strconv.ParseFloat(t.Value(key), 64)
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			v := t.Value(key)
+			f, err := strconv.ParseFloat(v, 64)
 			rf.SetFloat(float64(f))
+			return badvalue(err, v, "not a number")
 		}
 	case uint8, uint16, uint32, uint64, uint, int8, int16, int32, int64, int:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
-			i, _ := strconv.Atoi(t.Value(key))
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			v := t.Value(key)
+			i, err := strconv.Atoi(v)
 			rf.SetInt(int64(i))
+			return badvalue(err, v, "not an integer")
 		}
 	case string:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
 			rf.SetString(t.Value(key))
+			return nil
 		}
 	case []string:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
 			rf.Set(reflect.ValueOf(setSlice(t.Value(key))))
+			return nil
 		}
 	case time.Time:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
-			tm, _ := time.Parse(time.RFC3339Nano, t.Value(key))
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			v := t.Value(key)
+			tm, err := time.Parse(time.RFC3339Nano, v)
 			rf.Set(reflect.ValueOf(tm))
+			return badvalue(err, v, "not a date-time")
 		}
 	case time.Duration:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
-			d, _ := time.ParseDuration(t.Value(key) + "s")
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			v := t.Value(key)
+			d, err := time.ParseDuration(v + "s")
 			rf.Set(reflect.ValueOf(d))
+			return badvalue(err, v, "not a number")
 		}
 	case image.Point:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			v := t.Value(key)
 			p := image.Point{}
-			fmt.Sscanf(t.Value(key), "%dx%d", &p.X, &p.Y)
+			_, err := fmt.Sscanf(v, "%dx%d", &p.X, &p.Y)
 			rf.Set(reflect.ValueOf(p))
+			return badvalue(err, v, "not a resolution")
 		}
 	}
 	switch t := rf.Type(); t.Kind() {
+	case reflect.Ptr:
+		// optional values: the pointer stays nil unless
+		// the tag actually has a value for the key
+		dec := compileDec(reflect.New(t.Elem()).Elem())
+		if dec == nil {
+			return nil
+		}
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			if t.Value(key) == "" {
+				return nil
+			}
+			p := reflect.New(rf.Type().Elem())
+			err := dec(p.Elem(), t, key)
+			rf.Set(p)
+			return err
+		}
 	case reflect.Struct:
-		return func(rf reflect.Value, t m3u.Tag, key string) {
-			unmarshalAttr(rf, t)
+		return func(rf reflect.Value, t m3u.Tag, key string) error {
+			return unmarshalAttr(rf, t)
 		}
 	case reflect.Slice:
-		elem := reflect.New(t.Elem()).Elem()
-		register(elem, false)
-		return func(slice reflect.Value, t m3u.Tag, key string) {
-			unmarshalAttr(elem, t)
+		typ := t.Elem()
+		register(reflect.New(typ).Elem(), false)
+		return func(slice reflect.Value, t m3u.Tag, key string) error {
+			// a new element every time, since the decoder
+			// may be shared by concurrent decodes
+			elem := reflect.New(typ).Elem()
+			err := unmarshalAttr(elem, t)
 			slice.Set(reflect.Append(slice, elem))
+			return err
 		}
 	}
 	return nil
 }
 
+// badvalue returns a *ValueError if err is not nil. Absent
+// values are not malformed, so they never produce an error.
+func badvalue(err error, v, msg string) error {
+	if err == nil || v == "" {
+		return nil
+	}
+	return &ValueError{Text: v, Msg: msg}
+}
+
 func setSlice(s string) interface{} {
 	a := strings.Split(s, ",")
 	return a
@@ -3,6 +3,7 @@ package hls
 import (
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/as/hls/m3u"
 )
@@ -16,6 +17,12 @@ func unmarshalTag0(s interface{}, t ...m3u.Tag) error {
 	return nil
 }
 
+// unmarshalStrict is like unmarshalTag0, but it returns
+// the first malformed value as a *ValueError
+func unmarshalStrict(s interface{}, t ...m3u.Tag) error {
+	return unmarshalTag(reflect.ValueOf(s), t...)
+}
+
 func marshalTag(s reflect.Value) ([]m3u.Tag, error) {
 	sym := register(s, false)
 	// access all the fields that have m3u tags
@@ -67,7 +74,7 @@ func marshalTag(s reflect.Value) ([]m3u.Tag, error) {
 	return tags, nil
 }
 
-func unmarshalTag(s reflect.Value, t ...m3u.Tag) reflect.Value {
+func unmarshalTag(s reflect.Value, t ...m3u.Tag) (err error) {
 	type extra interface {
 		AddExtra(tag string, value interface{})
 	}
@@ -75,7 +82,12 @@ func unmarshalTag(s reflect.Value, t ...m3u.Tag) reflect.Value {
 	for _, t := range t {
 		f, ok := sym.field[t.Name]
 		if ok && f.set != nil {
-			f.set(s.Elem().Field(f.index), t, "")
+			if e := f.set(s.Elem().Field(f.index), t, ""); e != nil && err == nil {
+				err = e
+				if e, ok := e.(*ValueError); ok {
+					e.Line, e.Tag = t.Pos.Line, t.Name
+				}
+			}
 		}
 		if ok && f.kid != nil {
 			ptr := s.Elem().Field(f.index)
@@ -84,12 +96,19 @@ func unmarshalTag(s reflect.Value, t ...m3u.Tag) reflect.Value {
 				ptr.Set(z)
 			}
 			//fmt.Printf("set %#v field %d\n", ptr, f.kid.index)
-			f.kid.set(ptr.Elem().Field(f.kid.index), t, "")
+			if e := f.kid.set(ptr.Elem().Field(f.kid.index), t, ""); e != nil && err == nil {
+				err = e
+				if e, ok := e.(*ValueError); ok {
+					e.Line, e.Tag = t.Pos.Line, t.Name
+				}
+			}
 		}
 		if !ok {
 			file, ok := s.Interface().(extra)
 			if ok {
+				symmu.RLock()
 				new := extratag[t.Name]
+				symmu.RUnlock()
 				if new != nil {
 					tag := new()
 					unmarshalAttr(tag, t)
@@ -98,13 +117,35 @@ func unmarshalTag(s reflect.Value, t ...m3u.Tag) reflect.Value {
 			}
 		}
 	}
-	return s
+	return err
 }
 
-func unmarshalAttr(s reflect.Value, t m3u.Tag) error {
+func unmarshalAttr(s reflect.Value, t m3u.Tag) (err error) {
 	sym := register(s, true)
-	for name, lut := range sym.field {
-		lut.set(s.Field(lut.index), t, name)
+	for _, label := range sym.names {
+		lut := sym.field[label.name]
+		if label.name == "*" {
+			// the attributes without a field of their own
+			var extra Attrs
+			for _, k := range t.Keys {
+				if _, ok := sym.field[k]; !ok {
+					extra = append(extra, ClientAttr{k, attrof(t.Flag[k])})
+				}
+			}
+			s.Field(lut.index).Set(reflect.ValueOf(extra))
+			continue
+		}
+		e := lut.set(s.Field(lut.index), t, label.name)
+		if e, ok := e.(*ValueError); ok && err == nil {
+			if e.Attr == "" {
+				// positional values have no name of their own
+				e.Attr = label.name
+				if strings.HasPrefix(e.Attr, "$") {
+					e.Attr = strings.ToLower(s.Type().Field(lut.index).Name)
+				}
+			}
+			err = e
+		}
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,225 @@
+package hls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrMethod  = errors.New("hls: unsupported encryption method")
+	ErrPadding = errors.New("hls: bad padding")
+)
+
+// KeyFunc returns the key material for k, usually by fetching k.URI
+type KeyFunc func(k Key) ([]byte, error)
+
+// Vector returns the initialization vector for the segment with media
+// sequence number seq. If the key has an IV attribute it is used as-is,
+// otherwise the sequence number is the IV, as specified by the RFC.
+func (k Key) Vector(seq int) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if k.IV == "" {
+		binary.BigEndian.PutUint64(iv[8:], uint64(seq))
+		return iv, nil
+	}
+	s := strings.TrimPrefix(strings.TrimPrefix(k.IV, "0x"), "0X")
+	if len(s) > 2*aes.BlockSize {
+		return nil, fmt.Errorf("hls: iv too long: %s", k.IV)
+	}
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("hls: bad iv: %s", k.IV)
+	}
+	copy(iv[len(iv)-len(b):], b)
+	return iv, nil
+}
+
+// NewDecrypter returns a reader that decrypts the segment f read from r. The
+// segment's media sequence number, seq, is used when the key has no IV.
+// If f is not encrypted, r is returned as-is. Only METHOD=AES-128 with the
+// identity KEYFORMAT is supported, other keys return ErrMethod.
+func NewDecrypter(r io.Reader, f File, seq int, fetch KeyFunc) (io.Reader, error) {
+	block, iv, err := segmentcipher(f, seq, fetch)
+	if block == nil {
+		return r, err
+	}
+	return &decrypter{
+		src:  r,
+		mode: cipher.NewCBCDecrypter(block, iv),
+		tmp:  make([]byte, 32*1024),
+	}, nil
+}
+
+// NewEncrypter is the inverse of NewDecrypter. It returns a writer that
+// encrypts the segment f and writes it to w. The caller must call Close
+// to flush the final padded block; this does not close w.
+func NewEncrypter(w io.Writer, f File, seq int, fetch KeyFunc) (io.WriteCloser, error) {
+	block, iv, err := segmentcipher(f, seq, fetch)
+	if block == nil {
+		return nopCloser{w}, err
+	}
+	return &encrypter{
+		dst:  w,
+		mode: cipher.NewCBCEncrypter(block, iv),
+	}, nil
+}
+
+// segmentcipher returns the block cipher and iv for the segment, or
+// a nil block if the segment isn't encrypted
+func segmentcipher(f File, seq int, fetch KeyFunc) (cipher.Block, []byte, error) {
+	k, ok := f.Key.Format("identity")
+	if !ok {
+		// only keys in other formats, such as FairPlay
+		for _, k := range f.Key {
+			if k.Method != "" && k.Method != "NONE" {
+				return nil, nil, fmt.Errorf("%w: %s", ErrMethod, k.Method)
+			}
+		}
+		return nil, nil, nil
+	}
+	switch k.Method {
+	case "", "NONE":
+		return nil, nil, nil
+	case "AES-128":
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrMethod, k.Method)
+	}
+	key, err := fetch(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(key) != 16 {
+		return nil, nil, fmt.Errorf("hls: AES-128 key is %d bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv, err := k.Vector(seq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, iv, nil
+}
+
+type decrypter struct {
+	src  io.Reader
+	mode cipher.BlockMode
+
+	tmp   []byte
+	buf   []byte // ciphertext, less than one block
+	held  []byte // plaintext that might contain padding
+	ready []byte // plaintext
+	err   error
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.ready) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.fill()
+	}
+	n := copy(p, d.ready)
+	d.ready = d.ready[n:]
+	return n, nil
+}
+
+// fill decrypts the next chunk of the source. The last block is held
+// back until EOF, because that's the only block that has padding.
+func (d *decrypter) fill() {
+	n, err := d.src.Read(d.tmp)
+	d.buf = append(d.buf, d.tmp[:n]...)
+	if k := len(d.buf) / aes.BlockSize * aes.BlockSize; k > 0 {
+		d.mode.CryptBlocks(d.buf[:k], d.buf[:k])
+		d.held = append(d.held, d.buf[:k]...)
+		d.buf = append(d.buf[:0], d.buf[k:]...)
+	}
+	switch err {
+	case nil:
+	case io.EOF:
+		if len(d.buf) != 0 {
+			d.err = io.ErrUnexpectedEOF
+			return
+		}
+		d.ready, d.err = unpad(d.held)
+		if d.err == nil {
+			d.err = io.EOF
+		}
+		return
+	default:
+		d.err = err
+		return
+	}
+	if k := len(d.held) - aes.BlockSize; k > 0 {
+		d.ready = append(d.ready[:0], d.held[:k]...)
+		d.held = append(d.held[:0], d.held[k:]...)
+	}
+}
+
+func unpad(p []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, ErrPadding
+	}
+	n := int(p[len(p)-1])
+	if n == 0 || n > aes.BlockSize || n > len(p) {
+		return nil, ErrPadding
+	}
+	for _, c := range p[len(p)-n:] {
+		if int(c) != n {
+			return nil, ErrPadding
+		}
+	}
+	return p[:len(p)-n], nil
+}
+
+type encrypter struct {
+	dst  io.Writer
+	mode cipher.BlockMode
+	buf  []byte
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	if err := e.flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close pads and flushes the final block
+func (e *encrypter) Close() error {
+	n := aes.BlockSize - len(e.buf)%aes.BlockSize
+	for i := 0; i < n; i++ {
+		e.buf = append(e.buf, byte(n))
+	}
+	return e.flush()
+}
+
+func (e *encrypter) flush() error {
+	k := len(e.buf) / aes.BlockSize * aes.BlockSize
+	if k == 0 {
+		return nil
+	}
+	e.mode.CryptBlocks(e.buf[:k], e.buf[:k])
+	if _, err := e.dst.Write(e.buf[:k]); err != nil {
+		return err
+	}
+	e.buf = append(e.buf[:0], e.buf[k:]...)
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,287 @@
+package hls
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/as/hls/scte35"
+)
+
+// ErrNoTime is returned when a playlist has no EXT-X-PROGRAM-DATE-TIME
+// tags to position a date range with
+var ErrNoTime = errors.New("hls: playlist has no program date time")
+
+// CueFormat is one of the ways of signaling ad breaks recognized by AD
+type CueFormat int
+
+const (
+	FormatCue       CueFormat = iota // EXT-X-CUE-OUT, EXT-X-CUE-OUT-CONT and EXT-X-CUE-IN
+	FormatDateRange                  // EXT-X-DATERANGE with SCTE35-OUT and SCTE35-IN
+	FormatSCTE35                     // EXT-X-SCTE35
+	FormatAdobe                      // EXT-X-CUE (Adobe Primetime)
+)
+
+// Signal is a cue point normalized from any of the cue formats. Kind is
+// "out" at the start of a break, "in" at its end, and "cont" for the
+// segments in between.
+type Signal struct {
+	Kind     string
+	Format   CueFormat
+	ID       string
+	Duration time.Duration // planned duration of the break, zero if unknown
+	Elapsed  time.Duration // time since the start of the break
+	SCTE35   string        // splice_info_section in hex or base64, if any
+}
+
+// Splice decodes the SCTE-35 payload of the signal
+func (s Signal) Splice() (*scte35.Splice, error) {
+	return scte35.Parse(s.SCTE35)
+}
+
+// Signal returns the cue point signaled by the tags in a. If there are
+// several, the formats are tried in the order they are declared in. If
+// the tags don't specify a duration, it's taken from the SCTE-35 payload.
+func (a *AD) Signal() (s Signal, ok bool) {
+	if s, ok = a.signal(); ok && s.Duration == 0 && s.SCTE35 != "" {
+		if sp, err := s.Splice(); err == nil {
+			s.Duration, _ = sp.Duration()
+		}
+	}
+	return s, ok
+}
+
+func (a *AD) signal() (s Signal, ok bool) {
+	if a == nil {
+		return s, false
+	}
+	if c := a.Cue(); c.Set {
+		return Signal{Kind: c.Kind, Format: FormatCue, ID: c.ID, Duration: c.Duration, Elapsed: c.Elapsed, SCTE35: c.SCTE35}, true
+	}
+	if d := a.DateRange; d.IsAD() {
+		s = Signal{Kind: "out", Format: FormatDateRange, ID: d.ID, Duration: d.Planned, SCTE35: d.CueOut}
+		if d.Duration != 0 {
+			s.Duration = d.Duration
+		}
+		if d.CueOut == "" {
+			s.Kind, s.SCTE35 = "in", d.CueIn
+		}
+		return s, true
+	}
+	if c := a.SCTE35; c != (SCTE35{}) {
+		s = Signal{Format: FormatSCTE35, ID: c.ID, Duration: c.Duration, Elapsed: c.Elapsed, SCTE35: c.Cue}
+		switch {
+		case c.CueOut == "CONT":
+			s.Kind = "cont"
+		case c.CueOut != "":
+			s.Kind = "out"
+		case c.CueIn != "":
+			s.Kind = "in"
+		default:
+			return Signal{}, false
+		}
+		return s, true
+	}
+	if c := a.CueAdobe; c != (CueAdobe{}) {
+		s = Signal{Format: FormatAdobe, ID: c.ID, Duration: c.Duration, Elapsed: c.Elapsed}
+		switch {
+		case strings.EqualFold(c.Type, "SpliceIn"):
+			s.Kind = "in"
+		case c.Elapsed > 0:
+			s.Kind = "cont"
+		default:
+			s.Kind = "out"
+		}
+		return s, true
+	}
+	return s, false
+}
+
+// Signals returns the cue point of every segment in m.File. Segments
+// outside of a break have an empty Kind. Segments inside of a break are
+// "cont", even if they carry no tag, and their elapsed time is computed
+// from the segment durations unless a tag says otherwise. A break with
+// a duration and no "in" cue ends after that duration.
+func (m Media) Signals() []Signal {
+	sig := make([]Signal, len(m.File))
+	var cur *Signal
+	for i, f := range m.File {
+		s, ok := f.AD.Signal()
+		switch {
+		case ok && s.Kind == "out":
+			s.Elapsed = 0
+			cur = &s
+		case ok && s.Kind == "cont" && cur == nil:
+			// joined in the middle of a break
+			cur = &s
+		case cur != nil && (ok && s.Kind == "in" || cur.Duration > 0 && cur.Elapsed >= cur.Duration):
+			if !ok || s.Kind != "in" {
+				s = Signal{Kind: "in", Format: cur.Format}
+			}
+			s.Elapsed = cur.Elapsed
+			s.ID = or(s.ID, cur.ID)
+			s.Duration = or(s.Duration, cur.Duration)
+			cur = nil
+		case cur != nil:
+			if !ok {
+				s = Signal{Format: cur.Format}
+			}
+			s.Kind = "cont"
+			s.ID = or(s.ID, cur.ID)
+			s.Duration = or(s.Duration, cur.Duration)
+			s.Elapsed = or(s.Elapsed, cur.Elapsed)
+			s.SCTE35 = or(s.SCTE35, cur.SCTE35)
+			*cur = s
+		case !ok:
+			continue
+		}
+		sig[i] = s
+		if cur != nil {
+			cur.Elapsed = s.Elapsed + f.Duration(m.Target)
+		}
+	}
+	return sig
+}
+
+// ConvertCues returns a copy of m with its cue points rewritten in the
+// given format. The cue tags of all other formats are removed. Date
+// ranges are dated with the segments' program date times, and date
+// ranges that don't signal a break are kept.
+//
+// Breaks without an ID are given the media sequence number of their first
+// segment in m, since EXT-X-DATERANGE requires one. The SCTE-35 payload is
+// converted to hex for EXT-X-DATERANGE and to base64 for the others. A
+// date range without a payload is given a splice_insert, since SCTE35-OUT
+// and SCTE35-IN are what mark it as a break.
+//
+// A date range requires a START-DATE, so converting a break whose
+// segments have no program date time to FormatDateRange returns
+// ErrNoTime.
+func (m Media) ConvertCues(to CueFormat) (Media, error) {
+	sig := m.Signals()
+	ts := m.Timeline()
+	m.File = append([]File{}, m.File...)
+	var (
+		start Span
+		brk   bool
+	)
+	for i := range m.File {
+		f, s := &m.File[i], sig[i]
+		ad := AD{}
+		if f.AD != nil {
+			ad = *f.AD
+		}
+		ad.CueOut, ad.CueCont, ad.CueIn = Cue{}, Cue{}, Cue{}
+		ad.CueAdobe, ad.SCTE35 = CueAdobe{}, SCTE35{}
+		ad.SCTE35Splice, ad.SCTE35OatclsSplice = "", ""
+		if ad.DateRange.IsAD() {
+			ad.DateRange = DateRange{}
+		}
+		if s.Kind == "out" || (s.Kind != "" && !brk) {
+			// a break joined after its cue-out starts Elapsed earlier
+			start = ts[i]
+			if !start.Time.IsZero() {
+				start.Time = start.Time.Add(-s.Elapsed)
+			}
+		}
+		if s.Kind != "" {
+			brk = s.Kind != "in"
+		}
+		if s.Kind != "" && to == FormatDateRange {
+			if start.Time.IsZero() {
+				return m, fmt.Errorf("%w: segment %d", ErrNoTime, ts[i].Seq)
+			}
+			if s.ID == "" {
+				s.ID = strconv.Itoa(start.Seq)
+			}
+		}
+		switch to {
+		case FormatCue:
+			switch s.Kind {
+			case "out":
+				ad.CueOut = Cue{Duration: s.Duration, ID: s.ID, SCTE35: scte35base64(s.SCTE35), Set: true}
+			case "cont":
+				ad.CueCont = Cue{Duration: s.Duration, Elapsed: s.Elapsed, ID: s.ID, Set: true}
+			case "in":
+				ad.CueIn = Cue{Set: true}
+			}
+		case FormatDateRange:
+			if s.SCTE35 == "" && s.Kind != "cont" {
+				s.SCTE35 = scte35.NewInsert(eventid(s.ID), s.Kind == "out", s.Duration).Hex()
+			}
+			switch s.Kind {
+			case "out":
+				ad.DateRange = DateRange{ID: s.ID, Start: ts[i].Time, Planned: s.Duration, CueOut: scte35hex(s.SCTE35)}
+			case "in":
+				ad.DateRange = DateRange{ID: s.ID, Start: start.Time, End: start.Time.Add(s.Elapsed), Duration: s.Elapsed, CueIn: scte35hex(s.SCTE35)}
+			}
+		case FormatSCTE35:
+			switch s.Kind {
+			case "out":
+				ad.SCTE35 = SCTE35{ID: s.ID, Cue: scte35base64(s.SCTE35), Duration: s.Duration, CueOut: "YES"}
+			case "cont":
+				ad.SCTE35 = SCTE35{ID: s.ID, Cue: scte35base64(s.SCTE35), Duration: s.Duration, Elapsed: s.Elapsed, CueOut: "CONT"}
+			case "in":
+				ad.SCTE35 = SCTE35{ID: s.ID, CueIn: "YES"}
+			}
+		case FormatAdobe:
+			switch s.Kind {
+			case "out", "cont":
+				ad.CueAdobe = CueAdobe{ID: s.ID, Type: "SpliceOut", Duration: s.Duration, Elapsed: s.Elapsed}
+			case "in":
+				ad.CueAdobe = CueAdobe{ID: s.ID, Type: "SpliceIn"}
+			}
+		}
+		f.AD = &ad
+		if ad.IsZero() {
+			f.AD = nil
+		}
+	}
+	return m, nil
+}
+
+// scte35hex converts a base64 splice_info_section to hex
+func scte35hex(s string) string {
+	if s == "" || strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return s
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return "0x" + strings.ToUpper(hex.EncodeToString(b))
+}
+
+// scte35base64 converts a hex splice_info_section to base64
+func scte35base64(s string) string {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return s
+	}
+	b, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return s
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// eventid returns the splice event id for a break id
+func eventid(id string) uint32 {
+	if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+		return uint32(n)
+	}
+	return crc32.ChecksumIEEE([]byte(id))
+}
+
+// or returns v, or w if v is the zero value
+func or[T comparable](v, w T) T {
+	var zero T
+	if v == zero {
+		return w
+	}
+	return v
+}
@@ -0,0 +1,234 @@
+package hls
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/as/hls/m3u"
+)
+
+// AttrKind is the type of a client-defined attribute value
+type AttrKind int
+
+const (
+	AttrString AttrKind = iota // quoted-string
+	AttrHex                    // hexadecimal-sequence
+	AttrFloat                  // decimal-floating-point
+)
+
+// Attr is the value of a client-defined attribute, such as the X-
+// attributes of an EXT-X-DATERANGE. V is the value as it appears in the
+// playlist, without quotes.
+type Attr struct {
+	Kind AttrKind
+	V    string
+}
+
+// StringAttr returns a quoted-string attribute
+func StringAttr(s string) Attr {
+	return Attr{Kind: AttrString, V: s}
+}
+
+// HexAttr returns a hexadecimal-sequence attribute
+func HexAttr(b []byte) Attr {
+	return Attr{Kind: AttrHex, V: "0x" + strings.ToUpper(hex.EncodeToString(b))}
+}
+
+// FloatAttr returns a decimal-floating-point attribute
+func FloatAttr(f float64) Attr {
+	return Attr{Kind: AttrFloat, V: strconv.FormatFloat(f, 'f', -1, 64)}
+}
+
+// Float returns the value of a decimal-floating-point attribute
+func (a Attr) Float() (float64, error) {
+	return strconv.ParseFloat(a.V, 64)
+}
+
+// Bytes returns the value of a hexadecimal-sequence attribute
+func (a Attr) Bytes() ([]byte, error) {
+	v := strings.TrimPrefix(strings.TrimPrefix(a.V, "0x"), "0X")
+	if len(v)%2 == 1 {
+		v = "0" + v
+	}
+	return hex.DecodeString(v)
+}
+
+func (a Attr) String() string {
+	return a.value().String()
+}
+
+func (a Attr) value() m3u.Value {
+	return m3u.Value{V: a.V, Quote: a.Kind == AttrString}
+}
+
+// attrof infers the kind of an attribute from its syntax
+func attrof(v m3u.Value) Attr {
+	switch {
+	case v.Quote:
+		return StringAttr(v.V)
+	case hasprefix(v.V, "0x", "0X"):
+		return Attr{Kind: AttrHex, V: v.V}
+	}
+	return Attr{Kind: AttrFloat, V: v.V}
+}
+
+// ClientAttr is a named client-defined attribute
+type ClientAttr struct {
+	Name string
+	Attr
+}
+
+// Attrs is a list of client-defined attributes in the order they appear
+// in the playlist
+type Attrs []ClientAttr
+
+// Get returns the attribute with the given name
+func (a Attrs) Get(name string) (Attr, bool) {
+	for _, c := range a {
+		if c.Name == name {
+			return c.Attr, true
+		}
+	}
+	return Attr{}, false
+}
+
+// Set sets the attribute name to v, adding it to the end if it's new.
+// The name must start with X- and consist of A-Z, 0-9 and -, and v must
+// be a valid value of its kind.
+func (a *Attrs) Set(name string, v Attr) error {
+	if !strings.HasPrefix(name, "X-") || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") != "" {
+		return fmt.Errorf("hls: bad client attribute name: %q", name)
+	}
+	if err := v.check(); err != nil {
+		return fmt.Errorf("hls: client attribute %s: %w", name, err)
+	}
+	for i := range *a {
+		if (*a)[i].Name == name {
+			(*a)[i].Attr = v
+			return nil
+		}
+	}
+	*a = append(*a, ClientAttr{name, v})
+	return nil
+}
+
+// check returns an error if the value can't be written as its kind
+func (a Attr) check() error {
+	switch a.Kind {
+	case AttrString:
+		if strings.ContainsAny(a.V, "\"\r\n") {
+			return fmt.Errorf("quoted-string can't contain a double quote, CR or LF: %q", a.V)
+		}
+		return nil
+	case AttrHex:
+		if !hasprefix(a.V, "0x", "0X") || len(a.V) == 2 || strings.Trim(a.V[2:], "0123456789abcdefABCDEF") != "" {
+			return fmt.Errorf("bad hexadecimal-sequence: %q", a.V)
+		}
+		return nil
+	case AttrFloat:
+		_, err := a.Float()
+		return err
+	}
+	return fmt.Errorf("bad kind: %d", a.Kind)
+}
+
+// DateRanges returns the date ranges in m.File, merged by ID in the
+// order they first appear. A later EXT-X-DATERANGE with the same ID may
+// add attributes to the range, such as its END-DATE, but it must not
+// change the ones already set. The END-DATE of an END-ON-NEXT range is
+// set to the START-DATE of the next range with the same CLASS, if there
+// is one.
+//
+// The findings report conflicting attributes, END-ON-NEXT ranges without
+// a CLASS, and ranges that end before they start. The index of a finding
+// refers to m.File.
+func (m Media) DateRanges() ([]DateRange, []Finding) {
+	var fs findings
+	list, at := m.daterangeindex(&fs)
+	for j, d := range list {
+		if !d.EndNext {
+			continue
+		}
+		if d.Class == "" {
+			fs.add(Must, "daterange-end-on-next", "4.4.5.1", "EXT-X-DATERANGE", at[j], "date range %q has END-ON-NEXT, but no CLASS", d.ID)
+			continue
+		}
+		for _, e := range list {
+			if e.Class == d.Class && e.Start.After(d.Start) && (list[j].End.IsZero() || e.Start.Before(list[j].End)) {
+				list[j].End = e.Start
+			}
+		}
+	}
+	for j, d := range list {
+		if !d.End.IsZero() && d.End.Before(d.Start) {
+			fs.add(Must, "daterange-end", "4.4.5.1", "EXT-X-DATERANGE", at[j], "date range %q ends before it starts", d.ID)
+		}
+	}
+	return list, fs
+}
+
+// daterangeindex merges the date ranges in m.File by ID and reports the
+// conflicting attributes. It returns the index of the first segment of
+// each range.
+func (m Media) daterangeindex(fs *findings) (list []DateRange, at []int) {
+	id := map[string]int{}
+	for i, f := range m.File {
+		if f.AD == nil || f.AD.DateRange.IsZero() {
+			continue
+		}
+		d := f.AD.DateRange
+		j, ok := id[d.ID]
+		if !ok {
+			id[d.ID] = len(list)
+			d.Client = append(Attrs(nil), d.Client...)
+			list = append(list, d)
+			at = append(at, i)
+			continue
+		}
+		for _, name := range list[j].merge(d) {
+			fs.add(Must, "daterange-conflict", "4.4.5.1", "EXT-X-DATERANGE", i, "date range %q has a different %s", d.ID, name)
+		}
+	}
+	return list, at
+}
+
+// merge adds the attributes of e to c and returns the names of the ones
+// that are set to different values in both
+func (c *DateRange) merge(e DateRange) (conflict []string) {
+	v, w := reflect.ValueOf(c).Elem(), reflect.ValueOf(e)
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("hls"), ",")[0]
+		if name == "*" {
+			continue
+		}
+		a, b := v.Field(i), w.Field(i)
+		switch {
+		case b.IsZero():
+		case a.IsZero():
+			a.Set(b)
+		case !equal(a.Interface(), b.Interface()):
+			conflict = append(conflict, name)
+		}
+	}
+	for _, b := range e.Client {
+		a, ok := c.Client.Get(b.Name)
+		switch {
+		case !ok:
+			c.Client = append(c.Client, b)
+		case a != b.Attr:
+			conflict = append(conflict, b.Name)
+		}
+	}
+	return conflict
+}
+
+func equal(a, b any) bool {
+	if t, ok := a.(time.Time); ok {
+		return t.Equal(b.(time.Time))
+	}
+	return reflect.DeepEqual(a, b)
+}
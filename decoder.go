@@ -0,0 +1,186 @@
+package hls
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/as/hls/m3u"
+)
+
+// MediaDecoder reads a media playlist one segment at a time. It
+// never holds more than one segment's worth of tags in memory, so it's
+// suitable for long event and DVR playlists.
+//
+//	d := NewMediaDecoder(r)
+//	for d.Next() {
+//		f := d.File()
+//		...
+//	}
+//	if err := d.Err(); err != nil {
+//		...
+//	}
+//
+// The segments are the same as the ones Media.Decode stores in
+// Media.File, including the EXT-X-MAP and EXT-X-KEY tags that carry over
+// from one segment to the next.
+type MediaDecoder struct {
+	// URL is the location of the playlist. If set, it's used to resolve
+	// variables defined with EXT-X-DEFINE:QUERYPARAM.
+	URL string
+
+	lex interface {
+		Next() (m3u.Tag, error)
+	}
+
+	header MediaHeader
+	htag   []m3u.Tag // header tags, kept to rebuild the header
+	group  []m3u.Tag // tags since the last EXTINF
+	sticky File
+	file   File
+	part   []Part
+	n      int
+
+	vars     map[string]string
+	resolved bool
+	err      error
+}
+
+// NewMediaDecoder returns a MediaDecoder that reads from r
+func NewMediaDecoder(r io.Reader) *MediaDecoder {
+	return &MediaDecoder{lex: m3u.New(r)}
+}
+
+// Next decodes the next segment, which is then available through File. It
+// returns false when there are no more segments or an error occurs.
+func (d *MediaDecoder) Next() bool {
+	if d.err != nil {
+		return false
+	}
+	header := register(reflect.ValueOf(&d.header), false)
+	for {
+		t, err := d.lex.Next()
+		if err == io.EOF {
+			d.err = d.finish()
+			return false
+		}
+		if err != nil {
+			d.err = err
+			return false
+		}
+		switch t.Name {
+		case "EXT-X-MEDIA", "EXT-X-STREAM-INF", "EXT-X-I-FRAME-STREAM-INF":
+			if d.n == 0 {
+				d.err = ErrType
+				return false
+			}
+		}
+		if _, ok := header.field[t.Name]; ok {
+			unmarshalTag0(&d.header, t)
+			d.htag = append(d.htag, t)
+		}
+		d.group = append(d.group, t)
+		if t.Name != "EXTINF" {
+			continue
+		}
+		if !d.header.M3U {
+			d.err = ErrHeader
+			return false
+		}
+		if len(d.header.Define) == 0 {
+			if d.err = undefined(d.group...); d.err != nil {
+				return false
+			}
+		}
+		file := d.sticky
+		unmarshalTag0(&file, d.group...)
+		d.group = d.group[:0]
+		d.sticky = file.sticky()
+
+		// the sticky keys are shared with the next segment,
+		// so substitute variables in a copy
+		file.Key = append(Keys(nil), file.Key...)
+		if d.err = d.resolve(reflect.ValueOf(&file).Elem(), substitute); d.err != nil {
+			return false
+		}
+		d.file = file
+		d.n++
+		return true
+	}
+}
+
+// finish decodes the partial segments that trail the last segment
+func (d *MediaDecoder) finish() error {
+	if !d.header.M3U {
+		return ErrHeader
+	}
+	if len(d.header.Define) == 0 {
+		if err := undefined(d.group...); err != nil {
+			return err
+		}
+	}
+	tail := File{}
+	unmarshalTag0(&tail, d.group...)
+	d.group = nil
+	for i := range tail.Part {
+		if err := d.resolve(reflect.ValueOf(&tail.Part[i]).Elem(), substituteAttr); err != nil {
+			return err
+		}
+	}
+	d.part = tail.Part
+	if d.n == 0 && len(d.part) == 0 {
+		return ErrEmpty
+	}
+	return io.EOF
+}
+
+// resolve substitutes variable references in v, if the variables
+// defined so far can be resolved without outside knowledge
+func (d *MediaDecoder) resolve(v reflect.Value, fn func(reflect.Value, map[string]string) error) error {
+	if !d.resolved {
+		d.resolved = true
+		if len(d.header.Define) > 0 && selfcontained(d.header.Define, d.URL) {
+			vars, err := vars(d.header.Define, d.URL, nil)
+			if err != nil {
+				return err
+			}
+			d.vars = vars
+		}
+	}
+	if d.vars == nil {
+		return nil
+	}
+	return fn(v, d.vars)
+}
+
+// File returns the segment decoded by the last call to Next
+func (d *MediaDecoder) File() File {
+	return d.file
+}
+
+// Part returns the partial segments that trail the last segment
+// in a Low-Latency HLS playlist. It's only valid after Next returns false.
+func (d *MediaDecoder) Part() []Part {
+	return d.part
+}
+
+// Header returns the playlist header decoded so far. The tags that precede
+// the first segment are available after the first call to Next, but
+// trailing tags like EXT-X-ENDLIST only after Next returns false.
+func (d *MediaDecoder) Header() MediaHeader {
+	h := MediaHeader{}
+	unmarshalTag0(&h, d.htag...)
+	h.declared = h.Version
+	if d.vars != nil {
+		substitute(reflect.ValueOf(&h).Elem(), d.vars)
+	}
+	return h
+}
+
+// Err returns the first error encountered by Next, or nil if the
+// playlist was decoded successfully
+func (d *MediaDecoder) Err() error {
+	if d.err == io.EOF {
+		return nil
+	}
+	return d.err
+}
@@ -0,0 +1,261 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/as/hls/m3u"
+)
+
+// ErrVar is returned when a variable reference can not be resolved
+var ErrVar = errors.New("hls: undefined variable")
+
+// Define is the EXT-X-DEFINE tag. It defines a variable that can be
+// referenced as {$name} in URI lines and quoted-string attributes.
+// Exactly one of Name, Import or Query is set:
+//
+// Name and Value define the variable directly
+// Import imports a variable of the same name from the master playlist
+// Query takes the variable from the query string of the playlist URL
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis#section-4.4.2.3
+type Define struct {
+	Name   string `hls:"NAME,omitempty" json:",omitempty"`
+	Value  string `hls:"VALUE,omitempty" json:",omitempty"`
+	Import string `hls:"IMPORT,omitempty" json:",omitempty"`
+	Query  string `hls:"QUERYPARAM,omitempty" json:",omitempty"`
+}
+
+// VALUE is written even if its empty, since the empty string
+// is a perfectly good value for a variable
+func (d Define) settag(t *m3u.Tag) {
+	t.Flag = map[string]m3u.Value{}
+	set := func(k, v string) {
+		t.Keys = append(t.Keys, k)
+		t.Flag[k] = m3u.Value{V: v, Quote: true}
+	}
+	switch {
+	case d.Import != "":
+		set("IMPORT", d.Import)
+	case d.Query != "":
+		set("QUERYPARAM", d.Query)
+	default:
+		set("NAME", d.Name)
+		set("VALUE", d.Value)
+	}
+}
+
+// Vars returns the variables defined in the master playlist. Variables
+// defined with QUERYPARAM are taken from the query string of m.URL.
+func (m Master) Vars() (map[string]string, error) {
+	return vars(m.Define, m.URL, nil)
+}
+
+// Vars returns the variables defined in the media playlist. Variables
+// defined with QUERYPARAM are taken from the query string of m.URL, and
+// variables defined with IMPORT are taken from the parent master playlist,
+// which may be nil if the playlist was not loaded through one.
+func (m Media) Vars(parent *Master) (map[string]string, error) {
+	var imports map[string]string
+	if parent != nil {
+		var err error
+		if imports, err = parent.Vars(); err != nil {
+			return nil, err
+		}
+	}
+	return vars(m.Define, m.URL, imports)
+}
+
+// Resolve substitutes variable references in every URI and quoted-string
+// attribute of the playlist with the values returned by Vars. It returns
+// an error wrapping ErrVar if a reference can't be resolved.
+func (m *Master) Resolve() error {
+	v, err := m.Vars()
+	if err != nil {
+		return err
+	}
+	return substitute(reflect.ValueOf(m).Elem(), v)
+}
+
+// Resolve substitutes variable references in every URI and quoted-string
+// attribute of the playlist with the values returned by Vars. It returns
+// an error wrapping ErrVar if a reference can't be resolved.
+func (m *Media) Resolve(parent *Master) error {
+	v, err := m.Vars(parent)
+	if err != nil {
+		return err
+	}
+	if err := substitute(reflect.ValueOf(&m.MediaHeader).Elem(), v); err != nil {
+		return err
+	}
+	for i := range m.File {
+		if err := substitute(reflect.ValueOf(&m.File[i]).Elem(), v); err != nil {
+			return err
+		}
+	}
+	for i := range m.Part {
+		if err := substituteAttr(reflect.ValueOf(&m.Part[i]).Elem(), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selfcontained returns true if the definitions can be resolved without
+// any knowledge outside of the playlist itself. The decoders only resolve
+// variables automatically when this is the case.
+func selfcontained(def []Define, loc string) bool {
+	for _, d := range def {
+		if d.Import != "" || (d.Query != "" && loc == "") {
+			return false
+		}
+	}
+	return true
+}
+
+func vars(def []Define, loc string, imports map[string]string) (map[string]string, error) {
+	var query url.Values
+	if u, err := url.Parse(loc); err == nil {
+		query = u.Query()
+	}
+	v := map[string]string{}
+	for _, d := range def {
+		name, val, ok := d.Name, d.Value, true
+		switch {
+		case d.Import != "":
+			name = d.Import
+			val, ok = imports[name]
+		case d.Query != "":
+			name = d.Query
+			ok = query.Has(name)
+			val = query.Get(name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrVar, name)
+		}
+		if _, dup := v[name]; dup {
+			return nil, fmt.Errorf("hls: variable %s defined more than once", name)
+		}
+		v[name] = val
+	}
+	return v, nil
+}
+
+var varref = regexp.MustCompile(`\{\$[a-zA-Z0-9_-]+\}`)
+
+// undefined returns an error wrapping ErrVar for the first variable
+// reference in the URI lines and quoted-string attributes of t. It's
+// used when the playlist defines no variables.
+func undefined(t ...m3u.Tag) error {
+	for _, t := range t {
+		for _, k := range t.Keys {
+			if v := t.Flag[k]; v.Quote {
+				if _, err := expand(v.V, nil); err != nil {
+					return err
+				}
+			}
+		}
+		for _, l := range t.Line {
+			if _, err := expand(l, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expand replaces every variable reference in s
+func expand(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{$") {
+		return s, nil
+	}
+	var err error
+	s = varref.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		val, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("%w: %s", ErrVar, name)
+		}
+		return val
+	})
+	return s, err
+}
+
+var (
+	typeDefine = reflect.TypeOf(Define{})
+	typeTag    = reflect.TypeOf(m3u.Tag{})
+	typeTime   = reflect.TypeOf(time.Time{})
+)
+
+// substitute walks the tags in struct v and expands the
+// variables in their attributes
+func substitute(v reflect.Value, vars map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if parselabel(t.Field(i)) == nil {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Ptr:
+			if !f.IsNil() && f.Elem().Kind() == reflect.Struct {
+				if err := substitute(f.Elem(), vars); err != nil {
+					return err
+				}
+			}
+		case reflect.Struct:
+			if err := substituteAttr(f, vars); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.Struct {
+				continue
+			}
+			for j := 0; j < f.Len(); j++ {
+				if err := substituteAttr(f.Index(j), vars); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// substituteAttr expands the variables in URI lines and quoted-string
+// attributes of the tag represented by struct v
+func substituteAttr(v reflect.Value, vars map[string]string) error {
+	switch v.Type() {
+	case typeDefine, typeTag, typeTime:
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		l := parselabel(t.Field(i))
+		if l == nil || !(l.name == "$file" || l.quote && l.name != "" && !strings.HasPrefix(l.name, "$")) {
+			continue
+		}
+		f := v.Field(i)
+		switch f := f.Addr().Interface().(type) {
+		case *string:
+			s, err := expand(*f, vars)
+			if err != nil {
+				return err
+			}
+			*f = s
+		case *[]string:
+			for j := range *f {
+				s, err := expand((*f)[j], vars)
+				if err != nil {
+					return err
+				}
+				(*f)[j] = s
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/as/hls/m3u"
+)
+
+// Skip is the EXT-X-SKIP tag. It replaces the oldest Segments segments
+// of a playlist in a delta update. Removed lists the IDs of date ranges
+// that were removed from the playlist recently.
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis#section-4.4.5.2
+type Skip struct {
+	Segments int      `hls:"SKIPPED-SEGMENTS" json:",omitempty"`
+	Removed  []string `hls:"RECENTLY-REMOVED-DATERANGES,omitempty" json:",omitempty"`
+}
+
+// the date range list is tab separated, so this
+// can't use the default []string codec
+func (s Skip) settag(t *m3u.Tag) {
+	t.Flag = map[string]m3u.Value{}
+	t.Keys = append(t.Keys, "SKIPPED-SEGMENTS")
+	t.Flag["SKIPPED-SEGMENTS"] = m3u.Value{V: fmt.Sprint(s.Segments)}
+	if len(s.Removed) > 0 {
+		t.Keys = append(t.Keys, "RECENTLY-REMOVED-DATERANGES")
+		t.Flag["RECENTLY-REMOVED-DATERANGES"] = m3u.Value{V: strings.Join(s.Removed, "\t"), Quote: true}
+	}
+}
+
+func (s *Skip) decodetag(t m3u.Tag) {
+	s.Segments, _ = strconv.Atoi(t.Value("SKIPPED-SEGMENTS"))
+	s.Removed = nil
+	if v := t.Value("RECENTLY-REMOVED-DATERANGES"); v != "" {
+		s.Removed = strings.Split(v, "\t")
+	}
+}
+
+// Delta returns the delta update of m. Segments that end more than until
+// before the end of the playlist are replaced by an EXT-X-SKIP tag. If until
+// is zero, the CAN-SKIP-UNTIL attribute of m's EXT-X-SERVER-CONTROL tag is
+// used instead. If nothing can be skipped, the playlist is returned as-is.
+//
+// Date ranges attached to skipped segments are not carried over.
+func (m Media) Delta(until time.Duration) Media {
+	if until == 0 {
+		until = m.Control.CanSkipUntil
+	}
+	if until <= 0 {
+		return m
+	}
+	boundary := Runtime(m.File...) - until
+	n, end := 0, time.Duration(0)
+	for _, f := range m.File {
+		if end += f.Duration(m.Target); end > boundary {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return m
+	}
+	m.File = append([]File{}, m.File[n:]...)
+	m.Skip.Segments += n
+	if v := m.MinVersion(); m.Version < v {
+		m.Version = v
+	}
+	return m
+}
+
+// Merge applies the delta update d to m, a previously decoded full playlist,
+// and returns the full playlist described by d. If d is not a delta update, it
+// is returned as-is. It returns ErrSkip if m does not contain all of the skipped
+// segments.
+func (m Media) Merge(d Media) (Media, error) {
+	if d.Skip.Segments == 0 {
+		return d, nil
+	}
+	lo := d.Sequence - m.Sequence
+	hi := lo + d.Skip.Segments
+	if m.Skip.Segments != 0 || lo < 0 || hi > len(m.File) {
+		return d, ErrSkip
+	}
+	file := append([]File{}, m.File[lo:hi]...)
+	for i, f := range file {
+		if f.AD == nil || !removed(d.Skip.Removed, f.AD.DateRange.ID) {
+			continue
+		}
+		ad := *f.AD
+		ad.DateRange = DateRange{}
+		file[i].AD = &ad
+	}
+	d.File = append(file, d.File...)
+	d.Skip = Skip{}
+	return d, nil
+}
+
+func removed(list []string, id string) bool {
+	for _, v := range list {
+		if v != "" && v == id {
+			return true
+		}
+	}
+	return false
+}
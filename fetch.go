@@ -0,0 +1,21 @@
+package hls
+
+import (
+	"context"
+	"io"
+)
+
+// Fetcher fetches the resource located at url. Implementations
+// can retrieve it over the network, or from memory in tests.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// FetcherFunc is an adapter that allows an ordinary function
+// to be used as a Fetcher
+type FetcherFunc func(ctx context.Context, url string) (io.ReadCloser, error)
+
+// Fetch returns f(ctx, url)
+func (f FetcherFunc) Fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return f(ctx, url)
+}
@@ -3,6 +3,7 @@ package hls
 import (
 	"fmt"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -18,7 +19,7 @@ type File struct {
 	TimeMap       TimeMap   `hls:"EXT-X-TIMESTAMP-MAP,omitempty" json:",omitempty"`
 	Range         Range     `hls:"EXT-X-BYTERANGE,omitempty" json:",omitempty"`
 	Map           Map       `hls:"EXT-X-MAP,omitempty" json:",omitempty"`
-	Key           Key       `hls:"EXT-X-KEY,omitempty" json:",omitempty"`
+	Key           Keys      `hls:"EXT-X-KEY,aggr,omitempty" json:",omitempty"`
 
 	// Asset and other AD-related insertion fields. Most of these can be used to signal
 	// AD-insertion and many are redundant. The decoder only initializes [AD] if any of
@@ -27,6 +28,10 @@ type File struct {
 	PlacementOpp bool    `hls:"EXT-X-PLACEMENT-OPPORTUNITY,omitempty" json:",omitempty"`
 	AD           *AD     `hls:",embed,omitempty" json:",omitempty"`
 
+	// Part is the list of partial segments that make up this segment. These
+	// are only present in Low-Latency HLS playlists.
+	Part []Part `hls:"EXT-X-PART,aggr,omitempty" json:",omitempty"`
+
 	Extra map[string]interface{} `hls:"*,omitempty" json:",omitempty"`
 	Inf   Inf                    `hls:"EXTINF" json:",omitempty"`
 }
@@ -102,6 +107,65 @@ func (m *Key) Path(parent string) string {
 	return pathof(parent, m.URI)
 }
 
+// Keys is the set of keys that apply to a segment. A playlist can carry
+// several EXT-X-KEY tags at once, but only one for each KEYFORMAT. A new
+// EXT-X-KEY replaces the key with the same KEYFORMAT, and METHOD=NONE
+// clears the set.
+type Keys []Key
+
+// Format returns the key for the given KEYFORMAT. The empty string
+// is the same as "identity", the default.
+func (k Keys) Format(format string) (Key, bool) {
+	for _, v := range k {
+		if keyformat(v.Format) == keyformat(format) {
+			return v, true
+		}
+	}
+	return Key{}, false
+}
+
+// With returns a copy of k with key added to the set, replacing
+// any key with the same KEYFORMAT.
+func (k Keys) With(key Key) Keys {
+	set := make(Keys, 0, len(k)+1)
+	for _, v := range k {
+		if keyformat(v.Format) != keyformat(key.Format) {
+			set = append(set, v)
+		}
+	}
+	return append(set, key)
+}
+
+// Equal returns true if k and j contain the same keys
+func (k Keys) Equal(j Keys) bool {
+	if len(k) != len(j) {
+		return false
+	}
+	for _, v := range k {
+		if w, ok := j.Format(v.Format); !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *Keys) decodetag(t m3u.Tag) {
+	key := Key{}
+	unmarshalAttr(reflect.ValueOf(&key).Elem(), t)
+	if key.Method == "NONE" {
+		*k = nil
+		return
+	}
+	*k = k.With(key)
+}
+
+func keyformat(f string) string {
+	if f == "" {
+		return "identity"
+	}
+	return f
+}
+
 type Map struct {
 	URI       string `hls:"URI,omitempty" json:",omitempty"`
 	Byterange string `hls:"BYTERANGE,omitempty" json:",omitempty"`
@@ -4,6 +4,7 @@ package hls
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strings"
@@ -23,13 +24,63 @@ var (
 	ErrHeader = errors.New("hls: no m3u8 tag")
 	ErrEmpty  = errors.New("hls: empty playlist")
 	ErrType   = errors.New("hls: playlist type mismatch")
+	ErrSkip   = errors.New("hls: delta update does not apply to playlist")
 )
 
+// ValueError is a malformed tag or attribute value. It's returned by
+// the DecodeStrict methods, and by the Encode methods for a value that
+// can't be written as a quoted-string.
+type ValueError struct {
+	Line int    // line number of the tag, zero if unknown
+	Tag  string // tag name
+	Attr string // attribute name, empty if the value belongs to the tag itself
+	Text string // the malformed value
+	Msg  string
+}
+
+func (e *ValueError) Error() string {
+	s := e.Tag
+	if e.Attr != "" {
+		s += " " + e.Attr
+	}
+	s += " " + e.Msg
+	if e.Line > 0 {
+		s = fmt.Sprintf("line %d: %s", e.Line, s)
+	}
+	return fmt.Sprintf("hls: %s: %q", s, e.Text)
+}
+
+// unquotable returns a *ValueError for the first quoted-string in t that
+// contains a double quote, CR or LF. These have no escape sequence, so
+// writing them would break the playlist.
+func unquotable(t []m3u.Tag) error {
+	for _, t := range t {
+		for _, k := range t.Keys {
+			if v := t.Flag[k]; v.Quote && strings.ContainsAny(v.V, "\"\r\n") {
+				return &ValueError{Tag: t.Name, Attr: k, Text: v.V, Msg: "can't be a quoted-string"}
+			}
+		}
+	}
+	return nil
+}
+
 // Decode reads an HLS playlist from the reader and tokenizes
 // it into a list of tags. Master is true if and only if the input looks
 // like a master playlist.
 func Decode(r io.Reader) (t []m3u.Tag, master bool, err error) {
 	t, err = m3u.Parse(r)
+	return t, ismaster(t), err
+}
+
+// DecodeStrict is like Decode, except it returns a *m3u.SyntaxError
+// if the playlist is malformed. The tags record their position
+// in the input.
+func DecodeStrict(r io.Reader) (t []m3u.Tag, master bool, err error) {
+	t, err = m3u.ParseStrict(r)
+	return t, ismaster(t), err
+}
+
+func ismaster(t []m3u.Tag) bool {
 	for _, v := range t {
 		switch v.Name {
 		case "EXT-X-MEDIA":
@@ -37,13 +88,13 @@ func Decode(r io.Reader) (t []m3u.Tag, master bool, err error) {
 		case "EXT-X-STREAM-INF":
 			fallthrough
 		case "EXT-X-I-FRAME-STREAM-INF":
-			return t, true, err // master
+			return true // master
 		case "EXTINF":
-			return t, false, err // media
+			return false // media
 		}
 	}
 	// may be empty live media
-	return t, false, err
+	return false
 }
 
 // Runtime measures the cumulative duration of the given
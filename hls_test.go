@@ -1,11 +1,18 @@
 package hls
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
 	"image"
 	"io"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -28,6 +35,7 @@ func TestDecodeMaster(t *testing.T) {
 	want := Master{
 		M3U:         true,
 		Version:     3,
+		declared:    3,
 		Independent: true,
 		Stream: []StreamInfo{
 			{URL: "m1.m3u8", Bandwidth: 1111, BandwidthAvg: 1000, Resolution: image.Pt(1, 1), Codecs: []string{"avc1.4D401F", "mp4a.40.2"}, Framerate: 29.97},
@@ -64,12 +72,60 @@ func TestEncodeMasterBlaster(t *testing.T) {
 	}
 }
 
+func TestSessionData(t *testing.T) {
+	m := Master{URL: "https://example.com/movie/master.m3u8"}
+	if err := m.Decode(strings.NewReader(sampleMasterBlaster)); err != nil { // init.go:/sampleMasterBlaster/
+		t.Fatal(err)
+	}
+	want := []SessionData{
+		{ID: "com.example.title", Value: "Blaster", Lang: "en"},
+		{ID: "com.example.lyrics", URI: "lyrics.json"},
+	}
+	if !reflect.DeepEqual(m.SessionData, want) {
+		t.Fatalf("mismatch:\n\t\thave: %+v\n\t\twant: %+v", m.SessionData, want)
+	}
+	if h, w := m.SessionKey, []Key{{Method: "SAMPLE-AES", URI: "skd://key", Format: "com.apple.streamingkeydelivery", Versions: "1"}}; !reflect.DeepEqual(h, w) {
+		t.Fatalf("mismatch:\n\t\thave: %+v\n\t\twant: %+v", h, w)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="Blaster",LANGUAGE="en"`,
+		`#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://key",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("missing %s in:\n%s", line, buf)
+		}
+	}
+
+	fetch := FetcherFunc(func(ctx context.Context, url string) (io.ReadCloser, error) {
+		if url != "https://example.com/movie/lyrics.json" {
+			return nil, fmt.Errorf("not found: %s", url)
+		}
+		return io.NopCloser(strings.NewReader(`{"line": "hello"}`)), nil
+	})
+	var v struct{ Line string }
+	if err := m.SessionData[1].Load(context.Background(), fetch, m.URL, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Line != "hello" {
+		t.Fatalf("bad session data: %+v", v)
+	}
+	if err := m.SessionData[0].Load(context.Background(), fetch, m.URL, &v); err == nil {
+		t.Fatal("loaded session data without uri")
+	}
+}
+
 func TestDecodeMedia(t *testing.T) {
 	tm, _ := time.Parse("2006-01-02T15:04:05.000Z", "2021-01-11T07:59:41.005Z")
 	want := Media{
 		MediaHeader: MediaHeader{
 			M3U:           true,
 			Version:       3,
+			declared:      3,
 			Independent:   true,
 			Type:          "EVENT",
 			Target:        10 * time.Second,
@@ -101,6 +157,1619 @@ func TestDecodeMedia(t *testing.T) {
 	}
 }
 
+func TestDecodeLowLatency(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(sampleLowLatency)); err != nil { // init.go:/sampleLowLatency/
+		t.Fatal(err)
+	}
+	if h, w := m.PartInf.Target, 1004*time.Millisecond; h != w {
+		t.Fatalf("part target:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+	if h, w := m.File[1].Part, []Part{
+		{Duration: 2000040 * time.Microsecond, URI: "filePart267.0.mp4", Independent: true},
+		{Duration: 2000040 * time.Microsecond, URI: "filePart267.1.mp4"},
+	}; !reflect.DeepEqual(h, w) {
+		t.Fatalf("segment parts:\n\t\thave: %+v\n\t\twant: %+v", h, w)
+	}
+	if len(m.File[0].Part) != 0 {
+		t.Fatalf("first segment has parts: %+v", m.File[0].Part)
+	}
+	if h, w := m.Part, []Part{
+		{Duration: 1000010 * time.Microsecond, URI: "filePart268.0.mp4", Independent: true},
+		{Duration: 1000010 * time.Microsecond, URI: "filePart268.1.mp4", Gap: true},
+		{Duration: 1000010 * time.Microsecond, URI: "filePart268.mp4", Range: "20000@0"},
+	}; !reflect.DeepEqual(h, w) {
+		t.Fatalf("trailing parts:\n\t\thave: %+v\n\t\twant: %+v", h, w)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	m2 := Media{}
+	if err := m2.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Fatalf("round trip mismatch:\n\t\thave: %+v\n\t\twant: %+v", m2, m)
+	}
+}
+
+func TestEncodeLowLatency(t *testing.T) {
+	in := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=24,HOLD-BACK=12,PART-HOLD-BACK=3.012
+#EXT-X-PART-INF:PART-TARGET=1.004
+#EXT-X-MEDIA-SEQUENCE:266
+#EXTINF:4.00008
+fileSequence266.mp4
+#EXT-X-PART:DURATION=1.00001,URI="filePart267.0.mp4",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="filePart267.1.mp4"
+#EXT-X-PRELOAD-HINT:TYPE=MAP,URI="init.mp4",BYTERANGE-START=1024,BYTERANGE-LENGTH=512
+#EXT-X-RENDITION-REPORT:URI="../1M/waitForMSN.php",LAST-MSN=0,LAST-PART=0
+#EXT-X-RENDITION-REPORT:URI="../4M/waitForMSN.php"
+`
+	m := Media{}
+	if err := m.Decode(strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Report[0]; r.LastMSN == nil || r.LastPart == nil || *r.LastPart != 0 {
+		t.Fatalf("rendition report: %+v", r)
+	}
+	if r := m.Report[1]; r.LastMSN != nil || r.LastPart != nil {
+		t.Fatalf("rendition report: %+v", r)
+	}
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if h := buf.String(); h != in {
+		t.Fatalf("mismatch:\n\t\thave: %s\n\t\twant: %s", h, in)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	full := Media{MediaHeader: MediaHeader{M3U: true, Version: 9, Target: 4 * time.Second, Sequence: 100}}
+	full.Control.CanSkipUntil = 12 * time.Second
+	for i := 0; i < 10; i++ {
+		full.File = append(full.File, File{Inf: Inf{Duration: 4 * time.Second, URL: fmt.Sprintf("%d.ts", 100+i)}})
+	}
+
+	d := full.Delta(0)
+	if d.Skip.Segments != 7 || d.Len() != 3 {
+		t.Fatalf("delta: skipped %d, kept %d", d.Skip.Segments, d.Len())
+	}
+	if h, w := d.Seq(0), 107; h != w {
+		t.Fatalf("first sequence:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+
+	d.Skip.Removed = []string{"ad1", "ad2"}
+	buf := new(bytes.Buffer)
+	if err := d.Encode(buf); !errors.Is(err, ErrVersion) {
+		t.Fatalf("removed date ranges need version 10: have %v, want %v", err, ErrVersion)
+	}
+	d.Version = 10
+	if err := d.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "#EXT-X-SKIP:SKIPPED-SEGMENTS=7,RECENTLY-REMOVED-DATERANGES=\"ad1\tad2\"\n#EXTINF:4\n107.ts") {
+		t.Fatalf("bad delta encoding:\n%s", buf)
+	}
+	d = Media{}
+	if err := d.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := full.Merge(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.File, full.File) {
+		t.Fatalf("merge mismatch:\n\t\thave: %+v\n\t\twant: %+v", m.File, full.File)
+	}
+	if _, err = full.Delta(0).Merge(d); err != ErrSkip {
+		t.Fatalf("merge onto delta: have %v, want %v", err, ErrSkip)
+	}
+
+	// quoted-strings are written as is, so the ones that can't be
+	// written are rejected
+	d = full.Delta(0)
+	d.Version = 10
+	d.Skip.Removed = []string{`a"d`}
+	var ve *ValueError
+	if err := d.Encode(new(bytes.Buffer)); !errors.As(err, &ve) || ve.Attr != "RECENTLY-REMOVED-DATERANGES" {
+		t.Fatalf("double quote: have %v, want a *ValueError", err)
+	}
+	ms := Master{M3U: true, Media: []MediaInfo{{Type: "AUDIO", Group: "a", Name: "en\nfr"}}}
+	if err := ms.Encode(new(bytes.Buffer)); !errors.As(err, &ve) || ve.Attr != "NAME" {
+		t.Fatalf("line feed: have %v, want a *ValueError", err)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	master := Master{URL: "https://example.com/master.m3u8?token=abc"}
+	err := master.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-DEFINE:NAME="cdn",VALUE="https://cdn.example.com"
+#EXT-X-DEFINE:QUERYPARAM="token"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="English",URI="{$cdn}/audio.m3u8?t={$token}"
+#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO="aud"
+{$cdn}/video.m3u8?t={$token}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, w := master.Stream[0].URL, "https://cdn.example.com/video.m3u8?t=abc"; h != w {
+		t.Fatalf("stream url:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+	if h, w := master.Media[0].URI, "https://cdn.example.com/audio.m3u8?t=abc"; h != w {
+		t.Fatalf("media uri:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+
+	sample := `#EXTM3U
+#EXT-X-DEFINE:IMPORT="cdn"
+#EXT-X-DEFINE:NAME="key",VALUE="k1"
+#EXT-X-TARGETDURATION:10
+#EXT-X-MAP:URI="{$cdn}/init.mp4"
+#EXTINF:10,
+{$cdn}/0.m4s
+`
+	m := Media{}
+	if err := m.Decode(strings.NewReader(sample)); err != nil {
+		t.Fatal(err)
+	}
+	if h, w := m.File[0].Inf.URL, "{$cdn}/0.m4s"; h != w {
+		t.Fatalf("imported variable resolved without master:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+	if err := m.Resolve(nil); !errors.Is(err, ErrVar) {
+		t.Fatalf("resolve without master: have %v, want %v", err, ErrVar)
+	}
+	if err := m.Resolve(&master); err != nil {
+		t.Fatal(err)
+	}
+	if h, w := m.File[0].Map.URI, "https://cdn.example.com/init.mp4"; h != w {
+		t.Fatalf("map uri:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+
+	m = Media{}
+	err = m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-DEFINE:NAME="key",VALUE="k1"
+#EXTINF:10,
+{$nope}/0.m4s
+`))
+	if !errors.Is(err, ErrVar) {
+		t.Fatalf("undefined variable: have %v, want %v", err, ErrVar)
+	}
+
+	// references are rejected even if nothing is defined
+	undef := "#EXTM3U\n#EXT-X-MAP:URI=\"{$host}/init.mp4\"\n#EXTINF:10,\n0.m4s\n"
+	if err := (&Media{}).Decode(strings.NewReader(undef)); !errors.Is(err, ErrVar) {
+		t.Fatalf("no definitions: have %v, want %v", err, ErrVar)
+	}
+	d := NewMediaDecoder(strings.NewReader(undef))
+	for d.Next() {
+	}
+	if err := d.Err(); !errors.Is(err, ErrVar) {
+		t.Fatalf("no definitions, decoder: have %v, want %v", err, ErrVar)
+	}
+	if err := (&Master{}).Decode(strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n{$v}.m3u8\n")); !errors.Is(err, ErrVar) {
+		t.Fatalf("no definitions, master: have %v, want %v", err, ErrVar)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	sample := `#EXTM3U
+#EXT-X-VERSION:5
+#EXT-X-TARGETDURATION:6
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://k1",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="data:text/plain;base64,AAAA",KEYFORMAT="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed",KEYFORMATVERSIONS="1"
+#EXTINF:6
+0.ts
+#EXTINF:6
+1.ts
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://k2",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXTINF:6
+2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:6
+3.ts
+#EXTINF:6
+4.ts
+`
+	m := Media{}
+	if err := m.Decode(strings.NewReader(sample)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.File[1].Key); n != 2 {
+		t.Fatalf("segment 1 has %d keys, want 2", n)
+	}
+	if k, _ := m.File[2].Key.Format("com.apple.streamingkeydelivery"); k.URI != "skd://k2" {
+		t.Fatalf("fairplay key not rotated: %+v", m.File[2].Key)
+	}
+	if k, _ := m.File[2].Key.Format("urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"); k.URI != "data:text/plain;base64,AAAA" {
+		t.Fatalf("widevine key lost: %+v", m.File[2].Key)
+	}
+	if len(m.File[3].Key) != 0 || len(m.File[4].Key) != 0 {
+		t.Fatalf("METHOD=NONE did not clear keys: %+v", m.File[3].Key)
+	}
+	if h, w := m.Rotations(), []int{0, 2, 3}; !reflect.DeepEqual(h, w) {
+		t.Fatalf("rotations:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+
+	buf := new(bytes.Buffer)
+	m.Encode(buf)
+	if h, w := strings.Count(buf.String(), "#EXT-X-KEY:"), 5; h != w {
+		t.Fatalf("encoded %d keys, want %d:\n%s", h, w, buf)
+	}
+	if h := buf.String()[strings.LastIndex(buf.String(), "#EXT-X-KEY"):]; !strings.HasPrefix(h, "#EXT-X-KEY:METHOD=NONE\n#EXTINF:6\n3.ts") {
+		t.Fatalf("METHOD=NONE not emitted:\n%s", buf)
+	}
+	m2 := Media{}
+	m2.Decode(buf)
+	if !reflect.DeepEqual(m, m2) {
+		t.Fatalf("round trip mismatch:\n\t\thave: %+v\n\t\twant: %+v", m2, m)
+	}
+
+	// the key set shrinks from two formats to one
+	fp := Key{Method: "SAMPLE-AES", URI: "skd://k1", Format: "com.apple.streamingkeydelivery"}
+	wv := Key{Method: "SAMPLE-AES", URI: "data:text/plain;base64,AAAA", Format: "urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"}
+	m = Media{MediaHeader: MediaHeader{M3U: true, Target: 6 * time.Second}, File: []File{
+		{Inf: Inf{Duration: 6 * time.Second, URL: "0.ts"}, Key: Keys{fp, wv}},
+		{Inf: Inf{Duration: 6 * time.Second, URL: "1.ts"}, Key: Keys{fp}},
+	}}
+	buf.Reset()
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	m2 = Media{}
+	if err := m2.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if k := m2.File[1].Key; !k.Equal(Keys{fp}) {
+		t.Fatalf("shrinking key set:\n\t\thave: %+v\n\t\twant: %+v", k, Keys{fp})
+	}
+}
+
+func TestCryptAES128(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	fetch := func(k Key) ([]byte, error) { return key, nil }
+	f := File{Key: Keys{{Method: "AES-128", URI: "key.bin"}}}
+
+	for _, n := range []int{0, 1, 15, 16, 17, 100000} {
+		plain := bytes.Repeat([]byte{'x'}, n)
+		enc := new(bytes.Buffer)
+		w, err := NewEncrypter(enc, f, 42, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		w.Close()
+		if h, w := enc.Len(), (n/16+1)*16; h != w {
+			t.Fatalf("size %d: ciphertext is %d bytes, want %d", n, h, w)
+		}
+
+		// the iv is the sequence number when the key has no IV attribute
+		iv := make([]byte, aes.BlockSize)
+		iv[15] = 42
+		block, _ := aes.NewCipher(key)
+		want := append([]byte{}, enc.Bytes()...)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(want, want)
+		if !bytes.Equal(want[:n], plain) {
+			t.Fatalf("size %d: sequence number not used as iv", n)
+		}
+
+		r, err := NewDecrypter(bytes.NewReader(enc.Bytes()), f, 42, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", n, err)
+		}
+		if !bytes.Equal(have, plain) {
+			t.Fatalf("size %d: round trip mismatch", n)
+		}
+	}
+}
+
+func TestCryptPassthrough(t *testing.T) {
+	r := bytes.NewReader([]byte("clear"))
+	have, err := NewDecrypter(r, File{}, 0, nil)
+	if err != nil || have != io.Reader(r) {
+		t.Fatalf("unencrypted segment not passed through: %v", err)
+	}
+	f := File{Key: Keys{{Method: "SAMPLE-AES"}}}
+	if _, err := NewDecrypter(r, f, 0, nil); err == nil {
+		t.Fatal("SAMPLE-AES accepted")
+	}
+
+	f = File{Key: Keys{{Method: "SAMPLE-AES", URI: "skd://key", Format: "com.apple.streamingkeydelivery"}}}
+	if _, err := NewDecrypter(r, f, 0, nil); !errors.Is(err, ErrMethod) {
+		t.Fatalf("FairPlay key: have %v, want %v", err, ErrMethod)
+	}
+	if _, err := NewEncrypter(new(bytes.Buffer), f, 0, nil); !errors.Is(err, ErrMethod) {
+		t.Fatalf("FairPlay key: have %v, want %v", err, ErrMethod)
+	}
+}
+
+func TestKeyVector(t *testing.T) {
+	iv, err := Key{IV: "0x0000000000000000000000000000ABCD"}.Vector(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iv[14] != 0xab || iv[15] != 0xcd {
+		t.Fatalf("bad iv: %x", iv)
+	}
+	if _, err := (Key{IV: "0xzz"}).Vector(7); err == nil {
+		t.Fatal("bad iv accepted")
+	}
+}
+
+func TestSampleKey(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	fetch := func(k Key) ([]byte, error) { return key, nil }
+	for _, tc := range []struct {
+		method string
+		ok     bool
+	}{
+		{"SAMPLE-AES", true},
+		{"SAMPLE-AES-CTR", false},
+		{"AES-128", false},
+	} {
+		f := File{Key: Keys{{Method: tc.method, URI: "key.bin"}}}
+		err := DecryptTS(bytes.NewReader(nil), f, 1, fetch, nil)
+		if ok := err == nil; ok != tc.ok {
+			t.Fatalf("%s: DecryptTS: %v", tc.method, err)
+		}
+	}
+	if err := DecryptTS(bytes.NewReader(nil), File{}, 1, fetch, nil); err == nil {
+		t.Fatal("expected error for clear segment")
+	}
+}
+
+func TestMediaDecoder(t *testing.T) {
+	define := `#EXTM3U
+#EXT-X-DEFINE:NAME="cdn",VALUE="https://cdn.example.com"
+#EXT-X-TARGETDURATION:4
+#EXT-X-KEY:METHOD=AES-128,URI="{$cdn}/key.bin"
+#EXT-X-MAP:URI="{$cdn}/init.mp4"
+#EXTINF:4,
+{$cdn}/0.m4s
+#EXTINF:4,
+{$cdn}/1.m4s
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="{$cdn}/2.0.m4s"
+`
+	for _, sample := range []string{sampleMedia, sampleFrag, sampleCue, sampleLowLatency, define} {
+		want := Media{}
+		if err := want.Decode(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+		have := Media{}
+		d := NewMediaDecoder(strings.NewReader(sample))
+		for d.Next() {
+			have.File = append(have.File, d.File())
+		}
+		if err := d.Err(); err != nil {
+			t.Fatal(err)
+		}
+		have.MediaHeader, have.Part = d.Header(), d.Part()
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("mismatch:\n\t\thave: %+v\n\t\twant: %+v", have, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		in  string
+		err error
+	}{
+		{"", ErrHeader},
+		{"#EXTM3U", ErrEmpty},
+		{sampleMaster, ErrType},
+		{"#EXTM3U\n#EXT-X-DEFINE:NAME=\"a\",VALUE=\"b\"\n#EXTINF:1,\n{$c}.ts\n", ErrVar},
+	} {
+		d := NewMediaDecoder(strings.NewReader(tc.in))
+		for d.Next() {
+		}
+		if err := d.Err(); !errors.Is(err, tc.err) {
+			t.Fatalf("%q: have %v, want %v", tc.in, err, tc.err)
+		}
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	for _, sample := range []string{sampleMedia, sampleFrag, sampleLowLatency} {
+		m := Media{}
+		if err := m.DecodeStrict(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := Media{}
+	err := m.DecodeStrict(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+0.ts
+#EXTINF:ten,
+1.ts
+`))
+	want := &ValueError{Line: 5, Tag: "EXTINF", Attr: "duration", Text: "ten", Msg: "not a number"}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("mismatch:\n\t\thave: %v\n\t\twant: %v", err, want)
+	}
+	if h, w := err.Error(), `hls: line 5: EXTINF duration not a number: "ten"`; h != w {
+		t.Fatalf("error string:\n\t\thave: %s\n\t\twant: %s", h, w)
+	}
+
+	master := Master{}
+	err = master.DecodeStrict(strings.NewReader(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1000,RESOLUTION=wide
+a.m3u8
+`))
+	want = &ValueError{Line: 2, Tag: "EXT-X-STREAM-INF", Attr: "RESOLUTION", Text: "wide", Msg: "not a resolution"}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("mismatch:\n\t\thave: %v\n\t\twant: %v", err, want)
+	}
+
+	// the same playlist decodes without complaint in the default mode
+	master = Master{}
+	if err := master.Decode(strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000,RESOLUTION=wide\na.m3u8\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags may come between EXTINF and the URI
+	between := `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:100@0
+0.ts
+#EXTINF:4,
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXT-X-BYTERANGE:100@100
+0.ts
+`
+	for _, decode := range []func(*Media, io.Reader) error{(*Media).DecodeStrict, (*Media).Decode, (*Media).DecodeLossless} {
+		m := Media{}
+		if err := decode(&m, strings.NewReader(between)); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.File) != 2 || m.File[0].Inf.URL != "0.ts" || m.File[0].Range.V != "100@0" || m.File[1].Range.V != "100@100" {
+			t.Fatalf("tags between EXTINF and its uri: %+v", m.File)
+		}
+		if !m.File[1].Discontinuous || m.File[1].Time.IsZero() || m.File[0].Discontinuous {
+			t.Fatalf("tags between EXTINF and its uri: %+v", m.File)
+		}
+	}
+	m = Media{}
+	m.DecodeLossless(strings.NewReader(between))
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf); err != nil || buf.String() != between {
+		t.Fatalf("lossless round trip: %v\n%s", err, buf)
+	}
+}
+
+func TestLossless(t *testing.T) {
+	for _, sample := range []string{sampleMedia, sampleFrag, sampleCue, sampleLowLatency} {
+		m := Media{}
+		if err := m.DecodeLossless(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+		have := new(bytes.Buffer)
+		m.Encode(have)
+		if have.String() != sample {
+			t.Fatalf("media round trip:\n\t\thave: %q\n\t\twant: %q", have, sample)
+		}
+	}
+	for _, sample := range []string{sampleMaster, sampleMasterBlaster} {
+		m := Master{}
+		if err := m.DecodeLossless(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+		have := new(bytes.Buffer)
+		m.Encode(have)
+		if have.String() != sample {
+			t.Fatalf("master round trip:\n\t\thave: %q\n\t\twant: %q", have, sample)
+		}
+	}
+
+	sample := `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+0.m4s
+# the next segment is special
+#EXT-X-VENDOR-SEGMENT:ID=1
+#EXTINF:6.000,
+1.m4s
+#EXTINF:6.000,
+2.m4s
+`
+	for _, tc := range []struct {
+		name string
+		edit func(m *Media)
+		want string
+	}{
+		{"unchanged", func(m *Media) {}, sample},
+		{
+			"slide", func(m *Media) {
+				m.File = append(m.File[1:], File{Inf: Inf{Duration: 6 * time.Second, URL: "3.m4s"}, Map: m.File[0].Map})
+				m.Sequence++
+			}, `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MEDIA-SEQUENCE:1
+# the next segment is special
+#EXT-X-VENDOR-SEGMENT:ID=1
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6
+1.m4s
+#EXTINF:6.000,
+2.m4s
+#EXTINF:6
+3.m4s
+`,
+		},
+		{
+			"end", func(m *Media) {
+				m.File[2].Inf.URL = "two.m4s"
+				m.End = true
+			}, `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+0.m4s
+# the next segment is special
+#EXT-X-VENDOR-SEGMENT:ID=1
+#EXTINF:6.000,
+1.m4s
+#EXTINF:6
+two.m4s
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			"move", func(m *Media) {
+				m.File = []File{m.File[0], m.File[2], m.File[1]}
+			}, `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+0.m4s
+#EXTINF:6.000,
+2.m4s
+# the next segment is special
+#EXT-X-VENDOR-SEGMENT:ID=1
+#EXTINF:6
+1.m4s
+`,
+		},
+		{
+			"insert", func(m *Media) {
+				f := m.File[1]
+				f.Inf.URL = "0.5.m4s"
+				f.Extra = nil
+				m.File = append(m.File[:1], append([]File{f}, m.File[1:]...)...)
+			}, `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+0.m4s
+#EXTINF:6
+0.5.m4s
+# the next segment is special
+#EXT-X-VENDOR-SEGMENT:ID=1
+#EXTINF:6.000,
+1.m4s
+#EXTINF:6.000,
+2.m4s
+`,
+		},
+		{
+			"remove", func(m *Media) {
+				m.File = append(m.File[:1], m.File[2])
+			}, `#EXTM3U
+# packaged by vendor
+#EXT-X-TARGETDURATION:6
+#EXT-X-VERSION:7
+
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000,
+0.m4s
+#EXTINF:6.000,
+2.m4s
+`,
+		},
+	} {
+		m := Media{}
+		if err := m.DecodeLossless(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+		tc.edit(&m)
+		have := new(bytes.Buffer)
+		m.Encode(have)
+		if have.String() != tc.want {
+			t.Fatalf("%s:\n\t\thave: %q\n\t\twant: %q", tc.name, have, tc.want)
+		}
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	seg := func(f File) Media {
+		if f.Inf.Duration == 0 {
+			f.Inf.Duration = 4 * time.Second
+		}
+		f.Inf.URL = "0.ts"
+		return Media{MediaHeader: MediaHeader{M3U: true, Target: 4 * time.Second}, File: []File{f}}
+	}
+	for _, tc := range []struct {
+		m    Media
+		want int
+	}{
+		{seg(File{}), 1},
+		{seg(File{Key: Keys{{Method: "AES-128", URI: "k", IV: "0x1"}}}), 2},
+		{seg(File{Inf: Inf{Duration: 3500 * time.Millisecond}}), 3},
+		{seg(File{Range: Range{V: "100@0"}}), 4},
+		{seg(File{Key: Keys{{Method: "SAMPLE-AES", URI: "k", Format: "identity"}}}), 5},
+		{seg(File{Map: Map{URI: "init.mp4"}}), 6},
+		{Media{MediaHeader: MediaHeader{Define: []Define{{Name: "a", Value: "b"}}}}, 8},
+		{Media{MediaHeader: MediaHeader{Control: ServerControl{CanSkipUntil: time.Minute}}}, 9},
+		{Media{MediaHeader: MediaHeader{Define: []Define{{Query: "token"}}}}, 11},
+	} {
+		if h := tc.m.MinVersion(); h != tc.want {
+			t.Fatalf("%+v:\n\t\thave: %v\n\t\twant: %v", tc.m, h, tc.want)
+		}
+	}
+
+	// a map in an i-frame playlist only needs version 5
+	m := seg(File{Map: Map{URI: "init.mp4"}})
+	m.IFramesOnly = true
+	if h, w := m.MinVersion(), 5; h != w {
+		t.Fatalf("i-frames only:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+
+	// the encoder fills in a missing version, but doesn't raise one the caller set
+	m = seg(File{Map: Map{URI: "init.mp4"}, Inf: Inf{Duration: 3500 * time.Millisecond}})
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "#EXT-X-VERSION:6\n") {
+		t.Fatalf("version not set:\n%s", buf)
+	}
+	m.Version = 3
+	if err := m.Encode(buf); !errors.Is(err, ErrVersion) {
+		t.Fatalf("declared version too low: have %v, want %v", err, ErrVersion)
+	}
+
+	// a decoded playlist that declares too low a version is raised
+	low := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-KEY:METHOD=AES-128,URI="k",KEYFORMAT="identity"
+#EXTINF:4,
+0.ts
+`
+	for _, lossless := range []bool{false, true} {
+		m := Media{}
+		decode := m.Decode
+		if lossless {
+			decode = m.DecodeLossless
+		}
+		if err := decode(strings.NewReader(low)); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err := m.Encode(buf); err != nil {
+			t.Fatalf("lossless=%v: %v", lossless, err)
+		}
+		if !strings.Contains(buf.String(), "#EXT-X-VERSION:5\n") {
+			t.Fatalf("lossless=%v: version not raised:\n%s", lossless, buf)
+		}
+		m.Version = 4
+		if err := m.Encode(buf); !errors.Is(err, ErrVersion) {
+			t.Fatalf("lossless=%v: version set too low: have %v, want %v", lossless, err, ErrVersion)
+		}
+	}
+
+	master := Master{M3U: true, Version: 6, Stream: []StreamInfo{{URL: "a.m3u8", Bandwidth: 1}}}
+	master.Media = []MediaInfo{{Type: "CLOSED-CAPTIONS", Group: "cc", Name: "English", Instream: "SERVICE1"}}
+	if h, w := master.MinVersion(), 7; h != w {
+		t.Fatalf("instream service:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+	if err := master.Encode(buf); !errors.Is(err, ErrVersion) {
+		t.Fatalf("declared master version too low: have %v, want %v", err, ErrVersion)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, sample := range []string{sampleMedia, sampleFrag, sampleCue} {
+		m := Media{}
+		if err := m.Decode(strings.NewReader(sample)); err != nil {
+			t.Fatal(err)
+		}
+		if fs := m.Validate(); len(fs) != 0 {
+			t.Fatalf("sample has findings: %v", fs)
+		}
+	}
+
+	rules := func(fs []Finding) (s []string) {
+		for _, f := range fs {
+			s = append(s, fmt.Sprintf("%s %s %d", f.Severity, f.Rule, f.Index))
+		}
+		return s
+	}
+
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-PLAYLIST-TYPE:LIVE
+#EXTINF:6.4,
+0.ts
+#EXTINF:6.5,
+1.ts
+#EXT-X-KEY:METHOD=AES-128,IV=12
+#EXT-X-BYTERANGE:100
+#EXTINF:4,
+2.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"MUST playlist-type -1",
+		"MUST segment-target-duration 1",
+		"MUST byterange-offset 2",
+		"MUST key-uri 2",
+		"MUST key-iv 2",
+	}
+	if h := rules(m.Validate()); !reflect.DeepEqual(h, want) {
+		t.Fatalf("media findings:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+
+	master := Master{}
+	if err := master.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,AUTOSELECT=YES,URI="en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="French",DEFAULT=YES,AUTOSELECT=YES,URI="fr.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="sub",NAME="English",DEFAULT=YES,URI="en.vtt.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="cc",NAME="English",DEFAULT=YES,AUTOSELECT=NO,URI="cc.vtt.m3u8"
+#EXT-X-STREAM-INF:CODECS="avc1.4d401f,mp4a.40.2",AUDIO="aac",RESOLUTION=1280x720
+a.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000,CODECS="avc1.4d401f,mp4a.40.2",AUDIO="ac3"
+b.m3u8
+`)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"MUST media-default 1",
+		"MUST media-autoselect 2",
+		"MUST media-autoselect 3",
+		"MUST stream-bandwidth 0",
+		"SHOULD stream-resolution 1",
+		"MUST stream-group 1",
+	}
+	fs := master.Validate()
+	if h := rules(fs); !reflect.DeepEqual(h, want) {
+		t.Fatalf("master findings:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+	if h, w := fs[5].String(), `MUST stream-group (EXT-X-STREAM-INF[1], section 4.4.6.2): AUDIO group "ac3" has no EXT-X-MEDIA of TYPE=AUDIO`; h != w {
+		t.Fatalf("finding string:\n\t\thave: %s\n\t\twant: %s", h, w)
+	}
+}
+
+func TestCheckReload(t *testing.T) {
+	decode := func(s string) Media {
+		m := Media{}
+		if err := m.Decode(strings.NewReader(s)); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	prev := decode(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXTINF:4,
+10.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+11.ts
+#EXTINF:4,
+12.ts
+`)
+	good := decode(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:12
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXTINF:4,
+12.ts
+#EXTINF:4,
+13.ts
+`)
+	if fs := CheckReload(prev, good); len(fs) != 0 {
+		t.Fatalf("valid reload has findings: %v", fs)
+	}
+
+	bad := decode(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:12
+#EXTINF:3,
+12b.ts
+#EXTINF:4,
+13.ts
+`)
+	var have []string
+	for _, f := range CheckReload(prev, bad) {
+		have = append(have, fmt.Sprintf("%s %d", f.Rule, f.Index))
+	}
+	want := []string{
+		"reload-discontinuity-sequence -1",
+		"reload-segment-uri 0",
+		"reload-segment-duration 0",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("findings:\n\t\thave: %q\n\t\twant: %q", have, want)
+	}
+
+	prev.End = true
+	if fs := CheckReload(prev, decode(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:9
+#EXTINF:4,
+9.ts
+`)); len(fs) != 2 || fs[0].Rule != "reload-endlist" || fs[1].Rule != "reload-sequence" {
+		t.Fatalf("endlist and sequence: %v", fs)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-DISCONTINUITY-SEQUENCE:7
+#EXTINF:4,
+100.ts
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:04Z
+#EXTINF:2.5,
+101.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+ad0.ts
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T01:00:00Z
+#EXTINF:4,
+ad1.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		tm, _ := time.Parse(time.RFC3339, s)
+		return tm
+	}
+	want := []Span{
+		{Seq: 100, Discontinuity: 7, Start: 0, Duration: 4 * time.Second, Time: at("2020-01-01T00:00:00Z")},
+		{Seq: 101, Discontinuity: 7, Start: 4 * time.Second, Duration: 2500 * time.Millisecond, Time: at("2020-01-01T00:00:04Z")},
+		{Seq: 102, Discontinuity: 8, Start: 6500 * time.Millisecond, Duration: 4 * time.Second, Time: at("2020-01-01T00:59:56Z")},
+		{Seq: 103, Discontinuity: 8, Start: 10500 * time.Millisecond, Duration: 4 * time.Second, Time: at("2020-01-01T01:00:00Z")},
+	}
+	have := m.Timeline()
+	for i := range want {
+		if !reflect.DeepEqual(have[i], want[i]) {
+			t.Fatalf("span %d:\n\t\thave: %+v\n\t\twant: %+v", i, have[i], want[i])
+		}
+	}
+	if h, w := have[3].End(), 14500*time.Millisecond; h != w {
+		t.Fatalf("end:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-KEY:METHOD=AES-128,URI="k0"
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXTINF:4,
+100.mp4
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+101.mp4
+#EXTINF:4,
+102.mp4
+#EXTINF:4,
+103.mp4
+#EXT-X-ENDLIST
+`)); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2020, 1, 1, 0, 0, 8, 0, time.UTC)
+	for _, s := range []Media{
+		m.Slice(9*time.Second, 16*time.Second),
+		m.SliceTime(at.Add(time.Second), at.Add(time.Hour)),
+		m.SliceSeq(102, 200),
+	} {
+		if s.Sequence != 102 || s.Discontinuity != 1 || len(s.File) != 2 || !s.End {
+			t.Fatalf("bad slice: %+v", s.MediaHeader)
+		}
+		buf := new(bytes.Buffer)
+		if err := s.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		want := `#EXT-X-MEDIA-SEQUENCE:102
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:08Z
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-KEY:METHOD=AES-128,URI="k0"
+#EXTINF:4
+102.mp4
+`
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("encoded slice:\n%s\nwant:\n%s", buf, want)
+		}
+	}
+
+	if s := m.SliceSeq(0, 101); len(s.File) != 1 || s.Sequence != 100 || s.File[0].Time.IsZero() {
+		t.Fatalf("bad head: %+v", s)
+	}
+	if s := m.SliceSeq(200, 300); len(s.File) != 0 || s.Sequence != 104 {
+		t.Fatalf("bad empty slice: %+v", s)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(MediaHeader{Version: 3}, 3, 0)
+	for i := 0; i < 5; i++ {
+		f := File{Inf: Inf{Duration: 4 * time.Second, URL: fmt.Sprint(i, ".ts")}}
+		f.Discontinuous = i == 1
+		if err := w.Append(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := w.Media()
+	if m.Sequence != 2 || m.Discontinuity != 1 || m.Target != 4*time.Second || len(m.File) != 3 || m.File[0].Inf.URL != "2.ts" {
+		t.Fatalf("bad window: %+v", m)
+	}
+
+	w.AppendPart(Part{Duration: time.Second, URI: "5.0.ts"})
+	if m := w.Media(); len(m.Part) != 1 {
+		t.Fatalf("pending part not in snapshot: %+v", m.Part)
+	}
+	// the target duration doesn't change
+	if err := w.Append(File{Inf: Inf{Duration: 5500 * time.Millisecond, URL: "5.ts"}}); !errors.Is(err, ErrTarget) {
+		t.Fatalf("long segment: have %v, want %v", err, ErrTarget)
+	}
+	w.Append(File{Inf: Inf{Duration: 4400 * time.Millisecond, URL: "5.ts"}})
+	if m := w.Media(); len(m.Part) != 0 || len(m.Current().Part) != 1 || m.Target != 4*time.Second || m.Current().Inf.URL != "5.ts" {
+		t.Fatalf("part not moved into segment: %+v", m)
+	}
+
+	w = NewWindow(MediaHeader{}, 0, 8*time.Second)
+	for i := 0; i < 3; i++ {
+		w.Append(File{Inf: Inf{Duration: 4 * time.Second, URL: "x.ts"}})
+	}
+	if m := w.Media(); len(m.File) != 2 || m.Sequence != 1 {
+		t.Fatalf("bad window by duration: %+v", m)
+	}
+
+	// event playlists keep everything
+	w = NewWindow(MediaHeader{Type: Event}, 0, 8*time.Second)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			w.Append(File{Inf: Inf{Duration: 4 * time.Second, URL: "x.ts"}})
+			w.Media()
+			done <- true
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if m := w.Media(); len(m.File) != 4 || m.Sequence != 0 {
+		t.Fatalf("event playlist evicted segments: %+v", m.MediaHeader)
+	}
+	w.End()
+	if m := w.Media(); m.Type != Vod || !m.End {
+		t.Fatalf("event didn't become vod: %+v", m.MediaHeader)
+	}
+	if err := w.Append(File{}); err != ErrEnded {
+		t.Fatalf("append after end: have %v, want %v", err, ErrEnded)
+	}
+}
+
+func TestStitch(t *testing.T) {
+	decode := func(url, s string) Media {
+		m := Media{URL: url}
+		if err := m.Decode(strings.NewReader(s)); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	pre := decode("http://ads.example/pre/index.m3u8", `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXTINF:2,
+0.ts
+#EXT-X-ENDLIST
+`)
+	content := decode("http://cdn.example/vod/index.m3u8", `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:6
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:02Z
+#EXTINF:5.6,
+0.mp4
+#EXTINF:6,
+1.mp4
+#EXT-X-ENDLIST
+`)
+	more := decode("http://cdn.example/vod/more.m3u8", `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:13.6Z
+#EXTINF:6,
+2.mp4
+#EXT-X-ENDLIST
+`)
+	m, err := Stitch(pre, content, more)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Target != 6*time.Second || m.Version != 6 || !m.End {
+		t.Fatalf("bad header: %+v", m.MediaHeader)
+	}
+	want := []struct {
+		url  string
+		init string
+		disc bool
+	}{
+		{"http://ads.example/pre/0.ts", "", false},
+		{"http://cdn.example/vod/0.mp4", "http://cdn.example/vod/init.mp4", true},
+		{"http://cdn.example/vod/1.mp4", "http://cdn.example/vod/init.mp4", false},
+		{"http://cdn.example/vod/2.mp4", "http://cdn.example/vod/init.mp4", false},
+	}
+	if len(m.File) != len(want) {
+		t.Fatalf("have %d segments, want %d", len(m.File), len(want))
+	}
+	for i, w := range want {
+		f := m.File[i]
+		if f.Inf.URL != w.url || f.Map.URI != w.init || f.Discontinuous != w.disc {
+			t.Fatalf("segment %d:\n\t\thave: %q %q %v\n\t\twant: %q %q %v", i, f.Inf.URL, f.Map.URI, f.Discontinuous, w.url, w.init, w.disc)
+		}
+	}
+
+	// without program date times the boundary can't be proven continuous
+	more.File[0].Time = time.Time{}
+	if m, _ = Stitch(content, more); !m.File[2].Discontinuous {
+		t.Fatalf("boundary without pdt is continuous")
+	}
+	if _, err := Stitch(); err != ErrEmpty {
+		t.Fatalf("have %v, want %v", err, ErrEmpty)
+	}
+}
+
+func TestSplicer(t *testing.T) {
+	live := func(seq int, s string) Media {
+		m := Media{}
+		if err := m.Decode(strings.NewReader(fmt.Sprintf("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n%s", seq, s))); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	pod := Media{URL: "http://ads.example/pod/index.m3u8"}
+	for i := 0; i < 3; i++ {
+		pod.File = append(pod.File, File{Inf: Inf{Duration: 5 * time.Second, URL: fmt.Sprint("ad", i, ".ts")}})
+	}
+	calls := 0
+	s := &Splicer{Pod: func(b Break) (Media, error) {
+		calls++
+		if b.ID != "b1" || b.Seq != 1 || b.Duration != 12*time.Second {
+			t.Fatalf("bad break: %+v", b)
+		}
+		return pod, nil
+	}}
+	urls := func(m Media) (s []string) {
+		for _, f := range m.File {
+			u := f.Inf.URL
+			if f.Discontinuous {
+				u = "|" + u
+			}
+			s = append(s, u)
+		}
+		return s
+	}
+
+	m, err := s.Splice(live(0, `#EXTINF:4,
+c0.ts
+#EXT-X-CUE-OUT:DURATION=12,BREAKID=b1
+#EXTINF:4,
+c1.ts
+#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=4,DURATION=12
+#EXTINF:4,
+c2.ts
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"c0.ts", "|http://ads.example/pod/ad0.ts", "http://ads.example/pod/ad1.ts"}
+	if h := urls(m); !reflect.DeepEqual(h, want) {
+		t.Fatalf("first reload:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+
+	// the next reload slides the window, ends the break, and trims the last ad
+	m, err = s.Splice(live(2, `#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=4,DURATION=12
+#EXTINF:4,
+c2.ts
+#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=8,DURATION=12
+#EXTINF:4,
+c3.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+c4.ts
+#EXTINF:4,
+c5.ts
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"http://ads.example/pod/ad1.ts", "http://ads.example/pod/ad2.ts", "|c4.ts", "c5.ts"}
+	if h := urls(m); !reflect.DeepEqual(h, want) {
+		t.Fatalf("second reload:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+	if m.Sequence != 2 || m.Discontinuity != 1 || calls != 1 {
+		t.Fatalf("bad sequence: seq=%d disc=%d calls=%d", m.Sequence, m.Discontinuity, calls)
+	}
+	if d := m.File[1].Inf.Duration; d != 2*time.Second {
+		t.Fatalf("last ad not trimmed: %v", d)
+	}
+	if c := m.File[1].AD.Cue(); c.Kind != "cont" || c.Elapsed != 10*time.Second {
+		t.Fatalf("bad cue on ad: %+v", c)
+	}
+	if c := m.File[2].AD.Cue(); c.Kind != "in" {
+		t.Fatalf("bad cue after break: %+v", c)
+	}
+
+	// a short pod is filled with content
+	pod.File = pod.File[:1]
+	s = &Splicer{Pod: func(b Break) (Media, error) { return pod, nil }}
+	m, err = s.Splice(live(1, `#EXT-X-CUE-OUT:DURATION=12,BREAKID=b1
+#EXTINF:4,
+c1.ts
+#EXTINF:4,
+c2.ts
+#EXTINF:4,
+c3.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+c4.ts
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"|http://ads.example/pod/ad0.ts", "|c3.ts", "c4.ts"}
+	if h := urls(m); !reflect.DeepEqual(h, want) {
+		t.Fatalf("short pod:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+
+	// the content's own discontinuities are kept in the rest of the break
+	s = &Splicer{Pod: func(b Break) (Media, error) { return pod, nil }}
+	m, err = s.Splice(live(1, `#EXT-X-CUE-OUT:DURATION=16,BREAKID=b1
+#EXTINF:4,
+c1.ts
+#EXTINF:4,
+c2.ts
+#EXTINF:4,
+c3.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+c4.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+c5.ts
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"|http://ads.example/pod/ad0.ts", "|c3.ts", "|c4.ts", "c5.ts"}
+	if h := urls(m); !reflect.DeepEqual(h, want) {
+		t.Fatalf("discontinuity in break:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+}
+
+func TestConvertCues(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXTINF:4,
+0.ts
+#EXT-X-CUE-OUT:DURATION=8,BREAKID=b1,SCTE35=/DA=
+#EXTINF:4,
+1.ts
+#EXTINF:4,
+2.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+3.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	kinds := func(sig []Signal) (s []string) {
+		for _, v := range sig {
+			s = append(s, fmt.Sprintf("%s/%s/%v/%v", v.Kind, v.ID, v.Duration, v.Elapsed))
+		}
+		return s
+	}
+	want := []string{"//0s/0s", "out/b1/8s/0s", "cont/b1/8s/4s", "in/b1/8s/8s"}
+	if h := kinds(m.Signals()); !reflect.DeepEqual(h, want) {
+		t.Fatalf("signals:\n\t\thave: %q\n\t\twant: %q", h, want)
+	}
+
+	d, err := m.ConvertCues(FormatDateRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	d.Encode(buf)
+	for _, w := range []string{
+		`#EXT-X-DATERANGE:ID="b1",START-DATE="2020-01-01T00:00:04Z",PLANNED-DURATION=8,SCTE35-OUT=0xFC30`,
+		`#EXT-X-DATERANGE:ID="b1",START-DATE="2020-01-01T00:00:04Z",END-DATE="2020-01-01T00:00:12Z",DURATION=8,SCTE35-IN=0xFC`,
+	} {
+		if !strings.Contains(buf.String(), w) {
+			t.Fatalf("missing %s in:\n%s", w, buf)
+		}
+	}
+	if strings.Contains(buf.String(), "CUE-OUT") {
+		t.Fatalf("old cue tags not removed:\n%s", buf)
+	}
+
+	// the cue-in had no payload, so it's given a splice_insert
+	in, _ := d.File[3].AD.Signal()
+	sp, err := in.Splice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Insert == nil || sp.Insert.Out {
+		t.Fatalf("bad splice_insert for cue-in: %+v", sp.Insert)
+	}
+
+	// every format keeps the ids, durations and elapsed times
+	for _, to := range []CueFormat{FormatCue, FormatSCTE35, FormatAdobe} {
+		c, err := m.ConvertCues(to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := kinds(c.Signals()); !reflect.DeepEqual(h, want) {
+			t.Fatalf("format %d:\n\t\thave: %q\n\t\twant: %q", to, h, want)
+		}
+		if s := c.Signals()[1]; s.Format != to {
+			t.Fatalf("format %d: signal has format %d", to, s.Format)
+		}
+	}
+
+	// a break joined after its cue-out is dated and named from the
+	// segment it was joined on
+	m = Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:08Z
+#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=4,DURATION=8
+#EXTINF:4,
+7.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+8.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if d, err = m.ConvertCues(FormatDateRange); err != nil {
+		t.Fatal(err)
+	}
+	d.Encode(buf)
+	if w := `#EXT-X-DATERANGE:ID="7",START-DATE="2020-01-01T00:00:04Z",END-DATE="2020-01-01T00:00:12Z",DURATION=8,`; !strings.Contains(buf.String(), w) {
+		t.Fatalf("missing %s in:\n%s", w, buf)
+	}
+
+	// without a program date time there is no START-DATE to give it
+	m.File[0].Time = time.Time{}
+	if _, err := m.ConvertCues(FormatDateRange); !errors.Is(err, ErrNoTime) {
+		t.Fatalf("undated break: have %v, want %v", err, ErrNoTime)
+	}
+	if _, err := m.ConvertCues(FormatSCTE35); err != nil {
+		t.Fatalf("undated break: %v", err)
+	}
+}
+
+func TestBreaks(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=8,DURATION=12
+#EXTINF:4,
+0.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+1.ts
+#EXT-X-CUE-OUT:DURATION=30,BREAKID=b2
+#EXTINF:4,
+2.ts
+#EXT-X-CUE-OUT-CONT:ELAPSEDTIME=9,DURATION=30
+#EXTINF:4,
+3.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+4.ts
+#EXT-X-CUE-IN
+#EXTINF:4,
+5.ts
+#EXT-X-SCTE35:CUE-OUT=YES,ID="b3"
+#EXTINF:4,
+6.ts
+#EXT-X-ENDLIST
+`)); err != nil {
+		t.Fatal(err)
+	}
+	list, fs := m.Breaks()
+	want := []Break{
+		{Seq: 10, Duration: 12 * time.Second, Index: 0, Len: 1, Actual: 12 * time.Second, Closed: true},
+		{ID: "b2", Seq: 12, Duration: 30 * time.Second, Index: 2, Len: 2, Actual: 8 * time.Second, Closed: true},
+		{ID: "b3", Seq: 16, Format: FormatSCTE35, Index: 6, Len: 1, Actual: 4 * time.Second},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Fatalf("breaks:\n\t\thave: %+v\n\t\twant: %+v", list, want)
+	}
+	var have []string
+	for _, f := range fs {
+		have = append(have, fmt.Sprintf("%s %s %d", f.Rule, f.Tag, f.Index))
+	}
+	wantfs := []string{
+		"break-elapsed EXT-X-CUE-OUT-CONT 3",
+		"break-duration EXT-X-CUE-OUT 2",
+		"break-stray-in EXT-X-CUE-IN 5",
+		"break-unterminated EXT-X-SCTE35 6",
+	}
+	if !reflect.DeepEqual(have, wantfs) {
+		t.Fatalf("findings:\n\t\thave: %q\n\t\twant: %q", have, wantfs)
+	}
+}
+
+func TestInterstitial(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXTINF:4,
+0.ts
+#EXT-X-DATERANGE:ID="ad1",CLASS="com.apple.hls.interstitial",START-DATE="2020-01-01T00:00:04Z",X-ASSET-URI="ad1.m3u8",X-RESUME-OFFSET=0,X-SNAP="OUT,IN",X-RESTRICT="SKIP,JUMP"
+#EXTINF:4,
+1.ts
+#EXTINF:4,
+2.ts
+#EXT-X-ENDLIST
+`)); err != nil {
+		t.Fatal(err)
+	}
+	d := m.File[1].AD.DateRange
+	if !d.IsInterstitial() || d.AssetURI != "ad1.m3u8" || d.ResumeOffset == nil || *d.ResumeOffset != 0 {
+		t.Fatalf("bad interstitial: %+v", d)
+	}
+	if d.Snap != "OUT,IN" || d.Restrict != "SKIP,JUMP" {
+		t.Fatalf("bad snap or restrict: %q %q", d.Snap, d.Restrict)
+	}
+	if fs := m.Validate(); len(fs) != 0 {
+		t.Fatalf("findings: %v", fs)
+	}
+
+	// ad2 starts in segment 1, which is taken, so it goes on segment 2
+	s, err := m.Schedule(
+		DateRange{ID: "pre", Cue: CuePre + "," + CueOnce, AssetList: "pre.json"},
+		DateRange{ID: "ad2", Start: d.Start.Add(time.Second), AssetURI: "ad2.m3u8"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := s.Interstitials()
+	if len(have) != 3 || have[0].ID != "pre" || have[1].ID != "ad1" || have[2].ID != "ad2" {
+		t.Fatalf("bad schedule: %+v", have)
+	}
+	if !have[0].Start.Equal(m.File[0].Time) || !have[0].HasCue(CueOnce) {
+		t.Fatalf("bad pre-roll: %+v", have[0])
+	}
+	if m.File[0].AD != nil {
+		t.Fatal("schedule modified the original playlist")
+	}
+	if _, err := s.Schedule(DateRange{ID: "ad3", AssetURI: "ad3.m3u8"}); err == nil {
+		t.Fatal("scheduled an interstitial with no free segment")
+	}
+	if _, err := (Media{File: []File{{}}}).Schedule(DateRange{ID: "x", AssetURI: "x"}); err != ErrNoTime {
+		t.Fatalf("have %v, want %v", err, ErrNoTime)
+	}
+
+	fetch := FetcherFunc(func(ctx context.Context, url string) (io.ReadCloser, error) {
+		if url != "http://example.com/ads/pre.json" {
+			t.Fatalf("bad url: %s", url)
+		}
+		return io.NopCloser(strings.NewReader(`{"ASSETS":[{"URI":"a.m3u8","DURATION":10.5},{"URI":"b.m3u8","DURATION":4.5}]}`)), nil
+	})
+	list, err := have[0].LoadAssets(context.Background(), fetch, "http://example.com/ads/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Assets) != 2 || list.Duration() != 15*time.Second {
+		t.Fatalf("bad asset list: %+v", list)
+	}
+}
+
+func TestDateRanges(t *testing.T) {
+	m := Media{}
+	if err := m.Decode(strings.NewReader(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:00Z
+#EXT-X-DATERANGE:ID="a",CLASS="com.example.chapter",START-DATE="2020-01-01T00:00:00Z",END-ON-NEXT=YES,X-TITLE="One",X-ID=0x0A0B,X-WEIGHT=1.5
+#EXTINF:4,
+0.ts
+#EXT-X-DATERANGE:ID="b",CLASS="com.example.chapter",START-DATE="2020-01-01T00:00:04Z",X-TITLE="Two"
+#EXTINF:4,
+1.ts
+#EXT-X-DATERANGE:ID="a",START-DATE="2020-01-01T00:00:00Z",X-TITLE="Uno",X-NOTE="late"
+#EXTINF:4,
+2.ts
+#EXT-X-DATERANGE:ID="c",START-DATE="2020-01-01T00:00:08Z",END-DATE="2020-01-01T00:00:07Z"
+#EXTINF:4,
+3.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	a := m.File[0].AD.DateRange
+	want := Attrs{{"X-TITLE", StringAttr("One")}, {"X-ID", HexAttr([]byte{10, 11})}, {"X-WEIGHT", FloatAttr(1.5)}}
+	if !reflect.DeepEqual(a.Client, want) {
+		t.Fatalf("client attributes:\n\t\thave: %v\n\t\twant: %v", a.Client, want)
+	}
+	if v, _ := a.Client.Get("X-ID"); v != HexAttr([]byte{10, 11}) {
+		t.Fatalf("hex: have %v", v)
+	}
+	if v, _ := a.Client.Get("X-WEIGHT"); v.Kind != AttrFloat {
+		t.Fatalf("float: have %v", v)
+	}
+	if f, err := FloatAttr(1.5).Float(); err != nil || f != 1.5 {
+		t.Fatalf("float: have %v, %v", f, err)
+	}
+	c := append(Attrs(nil), a.Client...)
+	for _, bad := range []ClientAttr{{"TITLE", StringAttr("x")}, {"X-A=1,X-B", StringAttr("x")}, {"X-A", StringAttr(`x",X-B="y`)}, {"X-A", Attr{AttrHex, "12"}}, {"X-A", Attr{AttrFloat, "1,2"}}} {
+		if err := c.Set(bad.Name, bad.Attr); err == nil {
+			t.Fatalf("set %s=%s: no error", bad.Name, bad.Attr)
+		}
+	}
+	if err := c.Set("X-WEIGHT", FloatAttr(2)); err != nil || len(c) != 3 || c[2].V != "2" {
+		t.Fatalf("set X-WEIGHT: %v %v", err, c)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := `X-ASSET`; strings.Contains(buf.String(), s) {
+		t.Fatalf("encoded an empty interstitial attribute:\n%s", buf)
+	}
+	if s := `END-ON-NEXT=YES,X-TITLE="One",X-ID=0x0A0B,X-WEIGHT=1.5`; !strings.Contains(buf.String(), s) {
+		t.Fatalf("missing %s:\n%s", s, buf)
+	}
+
+	list, fs := m.DateRanges()
+	if len(list) != 3 || list[0].ID != "a" || list[1].ID != "b" || list[2].ID != "c" {
+		t.Fatalf("bad index: %+v", list)
+	}
+	if want := append(want, ClientAttr{"X-NOTE", StringAttr("late")}); !reflect.DeepEqual(list[0].Client, want) {
+		t.Fatalf("bad merge: %v", list[0].Client)
+	}
+	if _, ok := m.File[0].AD.DateRange.Client.Get("X-NOTE"); ok {
+		t.Fatal("merge modified the playlist")
+	}
+	if !list[0].End.Equal(list[1].Start) {
+		t.Fatalf("END-ON-NEXT: have end %v, want %v", list[0].End, list[1].Start)
+	}
+	var have []string
+	for _, f := range fs {
+		have = append(have, fmt.Sprintf("%s %d", f.Rule, f.Index))
+	}
+	wantfs := []string{"daterange-conflict 2", "daterange-end 3"}
+	if !reflect.DeepEqual(have, wantfs) {
+		t.Fatalf("findings:\n\t\thave: %q\n\t\twant: %q", have, wantfs)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	media := func(name string) string {
+		return "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-DEFINE:IMPORT=\"cdn\"\n#EXTINF:4,\n{$cdn}/" + name + "/0.ts\n#EXT-X-ENDLIST\n"
+	}
+	fs := map[string]string{
+		"http://example.com/live/master.m3u8": `#EXTM3U
+#EXT-X-DEFINE:NAME="cdn",VALUE="http://cdn.example.com"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="English",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="English",INSTREAM-ID="CC1"
+#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO="aud"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000,AUDIO="aud"
+high/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000,AUDIO="aud",PATHWAY-ID="B"
+high/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100,URI="/iframe.m3u8"
+`,
+		"http://example.com/live/low/index.m3u8":  media("low"),
+		"http://example.com/live/high/index.m3u8": media("high"),
+		"http://example.com/live/audio/en.m3u8":   media("en"),
+		"http://example.com/iframe.m3u8":          media("iframe"),
+	}
+	var (
+		mu              sync.Mutex
+		fetched         = map[string]int{}
+		active, maxload int
+	)
+	fetch := FetcherFunc(func(ctx context.Context, url string) (io.ReadCloser, error) {
+		mu.Lock()
+		fetched[url]++
+		active++
+		if active > maxload {
+			maxload = active
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		s, ok := fs[url]
+		if !ok {
+			return nil, fmt.Errorf("not found: %s", url)
+		}
+		return io.NopCloser(strings.NewReader(s)), nil
+	})
+
+	tree, err := Loader{Fetcher: fetch, Limit: 2}.Load(context.Background(), "http://example.com/live/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Media) != 4 {
+		t.Fatalf("have %d media playlists, want 4: %v", len(tree.Media), fetched)
+	}
+	for u, n := range fetched {
+		if n != 1 {
+			t.Fatalf("fetched %s %d times", u, n)
+		}
+	}
+	if maxload > 2 {
+		t.Fatalf("%d concurrent fetches, limit is 2", maxload)
+	}
+	m := tree.Media[tree.Master.Stream[1].Path(tree.Master.URL)]
+	if m.URL != "http://example.com/live/high/index.m3u8" || len(m.File) != 1 {
+		t.Fatalf("bad media playlist: %+v", m)
+	}
+	if u := m.File[0].Inf.URL; u != "http://cdn.example.com/high/0.ts" {
+		t.Fatalf("imported variable: have %q", u)
+	}
+
+	delete(fs, "http://example.com/live/audio/en.m3u8")
+	if _, err := (Loader{Fetcher: fetch}).Load(context.Background(), "http://example.com/live/master.m3u8"); err == nil {
+		t.Fatal("loaded a tree with a missing playlist")
+	}
+}
+
 func TestDecodeValidation(t *testing.T) {
 	m := Media{}
 	h, w := m.Decode(strings.NewReader("")), ErrHeader
@@ -13,21 +13,56 @@ func js(v any) string {
 }
 
 func writeplaylist(m Media, w io.Writer) error {
+	m.File = stripsticky(m)
+	tags, err := m.EncodeTag()
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		fmt.Fprintln(w, t)
+	}
+	return nil
+}
+
+// stripsticky returns a copy of the segments in m without the EXT-X-MAP
+// and EXT-X-KEY tags that carry over from the previous segment
+func stripsticky(m Media) []File {
 	init := ""
-	m.File = append([]File{}, m.File...)
-	for i := 0; i < len(m.File); i++ {
-		f := &m.File[i]
+	rotation := m.Rotations()
+	file := append([]File{}, m.File...)
+	for i := 0; i < len(file); i++ {
+		f := &file[i]
 		if init == f.Map.URI && !f.Discontinuous {
 			f.Map.URI = ""
 		} else {
 			init = f.Map.URI
 		}
+		switch {
+		case len(rotation) == 0 || rotation[0] != i:
+			f.Key = nil
+		case len(f.Key) == 0:
+			rotation = rotation[1:]
+			f.Key = Keys{{Method: "NONE"}}
+		case i > 0 && dropsformat(m.File[i-1].Key, f.Key):
+			// the decoder merges a key into the active set, so
+			// the set has to be cleared before it can shrink
+			rotation = rotation[1:]
+			f.Key = append(Keys{{Method: "NONE"}}, f.Key...)
+		default:
+			rotation = rotation[1:]
+		}
 	}
-	tags, _ := m.EncodeTag()
-	for _, t := range tags {
-		fmt.Fprintln(w, t)
+	return file
+}
+
+// dropsformat returns true if a KEYFORMAT in prev is not in cur
+func dropsformat(prev, cur Keys) bool {
+	for _, k := range prev {
+		if _, ok := cur.Format(k.Format); !ok {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
 func init() {
@@ -41,6 +76,8 @@ func init() {
 	m3.Decode(strings.NewReader(sampleCue))
 	m4 := Master{}
 	m4.Decode(strings.NewReader(sampleMasterBlaster))
+	m5 := Media{}
+	m5.Decode(strings.NewReader(sampleLowLatency))
 }
 
 var sampleMedia = `
@@ -150,6 +187,9 @@ var sampleMasterBlaster = `
 #EXT-X-VERSION:7
 #EXT-X-CONTENT-STEERING:SERVER-URI="/steering?video=00012",PATHWAY-ID="CDN-A" 
 #EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="Blaster",LANGUAGE="en"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.lyrics",URI="lyrics.json"
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://key",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
 #EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud_1_en",NAME="English",DEFAULT=NO,AUTOSELECT=YES,LANGUAGE="en",CHANNELS="2",URI="variant_0.m3u8"
 #EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="ALL_SUBTITLES_GROUP",NAME="English",DEFAULT=YES,AUTOSELECT=YES,LANGUAGE="en",FORCED=NO,CHARACTERISTICS="public.accessibility.transcribes-spoken-dialog,public.accessibility.describes-music-and-sound",URI="variant_1.m3u8"
 #EXT-X-STREAM-INF:PROGRAM-ID=0,BANDWIDTH=1785306,AVERAGE-BANDWIDTH=723720,CODECS="avc1.4D401F,mp4a.40.2",RESOLUTION=768x432,AUDIO="aud_1_en",SUBTITLES="ALL_SUBTITLES_GROUP",FRAME-RATE=23.976,VIDEO-RANGE=SDR
@@ -174,3 +214,25 @@ variant_8.m3u8
 #EXT-X-I-FRAME-STREAM-INF:PROGRAM-ID=0,BANDWIDTH=79536,CODECS="avc1.640028",RESOLUTION=1280x720,URI="iframe_7.m3u8"
 #EXT-X-I-FRAME-STREAM-INF:PROGRAM-ID=0,BANDWIDTH=271840,CODECS="avc1.640028",RESOLUTION=1920x1080,URI="iframe_8.m3u8"
 `
+
+var sampleLowLatency = `
+#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=24,PART-HOLD-BACK=3.012
+#EXT-X-PART-INF:PART-TARGET=1.004
+#EXT-X-MEDIA-SEQUENCE:266
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.00008,
+fileSequence266.mp4
+#EXT-X-PART:DURATION=2.00004,URI="filePart267.0.mp4",INDEPENDENT=YES
+#EXT-X-PART:DURATION=2.00004,URI="filePart267.1.mp4"
+#EXTINF:4.00008,
+fileSequence267.mp4
+#EXT-X-PART:DURATION=1.00001,URI="filePart268.0.mp4",INDEPENDENT=YES
+#EXT-X-PART:DURATION=1.00001,URI="filePart268.1.mp4",GAP=YES
+#EXT-X-PART:DURATION=1.00001,URI="filePart268.mp4",BYTERANGE="20000@0"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="filePart268.3.mp4"
+#EXT-X-RENDITION-REPORT:URI="../1M/waitForMSN.php",LAST-MSN=268,LAST-PART=0
+#EXT-X-RENDITION-REPORT:URI="../4M/waitForMSN.php",LAST-MSN=268,LAST-PART=1
+`
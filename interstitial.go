@@ -0,0 +1,173 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InterstitialClass is the CLASS of an EXT-X-DATERANGE that schedules
+// an HLS interstitial, see:
+//
+// https://developer.apple.com/streaming/GettingStartedWithHLSInterstitials.pdf
+const InterstitialClass = "com.apple.hls.interstitial"
+
+// Values of the CUE attribute of an interstitial
+const (
+	CuePre  = "PRE"  // play before the primary content
+	CuePost = "POST" // play after the primary content
+	CueOnce = "ONCE" // play only once
+)
+
+// IsInterstitial returns true if the date range schedules an interstitial
+func (c DateRange) IsInterstitial() bool {
+	return c.Class == InterstitialClass
+}
+
+// HasCue returns true if the CUE attribute contains the value v, one of
+// CuePre, CuePost or CueOnce
+func (c DateRange) HasCue(v string) bool {
+	for _, s := range strings.Split(c.Cue, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Interstitials returns the interstitials scheduled in m.File, in order
+func (m Media) Interstitials() (d []DateRange) {
+	for _, f := range m.File {
+		if f.AD != nil && f.AD.DateRange.IsInterstitial() {
+			d = append(d, f.AD.DateRange)
+		}
+	}
+	return d
+}
+
+// Schedule returns a copy of m with the interstitials in d scheduled on
+// its segments. A date range is placed on the segment that contains its
+// START-DATE, or the first or last segment if it falls outside of the
+// playlist. A PRE interstitial goes on the first segment and a POST
+// interstitial on the last, and both are given that segment's START-DATE
+// if they have none. A segment can carry only one date range, so if it's
+// taken, the nearest free segment is used instead.
+//
+// An empty CLASS is set to InterstitialClass. The playlist must have a
+// program date time.
+func (m Media) Schedule(d ...DateRange) (Media, error) {
+	if len(m.File) == 0 {
+		return m, ErrEmpty
+	}
+	ts := m.Timeline()
+	if ts[0].Time.IsZero() {
+		return m, ErrNoTime
+	}
+	m.File = append([]File{}, m.File...)
+	for _, d := range d {
+		if d.Class == "" {
+			d.Class = InterstitialClass
+		}
+		if d.ID == "" {
+			return m, errors.New("hls: interstitial has no id")
+		}
+		if (d.AssetURI == "") == (d.AssetList == "") {
+			return m, fmt.Errorf("hls: interstitial %s: needs one of X-ASSET-URI or X-ASSET-LIST", d.ID)
+		}
+		i := 0
+		switch {
+		case d.HasCue(CuePre):
+		case d.HasCue(CuePost):
+			i = len(ts) - 1
+		default:
+			i = position(ts, d.Start)
+		}
+		if d.Start.IsZero() {
+			d.Start = ts[i].Time
+		}
+		i = m.free(i)
+		if i < 0 {
+			return m, fmt.Errorf("hls: interstitial %s: no segment is free", d.ID)
+		}
+		ad := AD{}
+		if f := m.File[i]; f.AD != nil {
+			ad = *f.AD
+		}
+		ad.DateRange = d
+		m.File[i].AD = &ad
+	}
+	return m, nil
+}
+
+// position returns the index of the span containing t
+func position(ts []Span, t time.Time) int {
+	for i, s := range ts {
+		if t.Before(s.Time.Add(s.Duration)) {
+			return i
+		}
+	}
+	return len(ts) - 1
+}
+
+// free returns the index of the segment nearest to i without a date
+// range, or -1 if there is none
+func (m Media) free(i int) int {
+	taken := func(j int) bool {
+		return m.File[j].AD != nil && !m.File[j].AD.DateRange.IsZero()
+	}
+	for n := 0; n < len(m.File); n++ {
+		if j := i + n; j < len(m.File) && !taken(j) {
+			return j
+		}
+		if j := i - n; j >= 0 && !taken(j) {
+			return j
+		}
+	}
+	return -1
+}
+
+// AssetList is the JSON document referenced by X-ASSET-LIST
+type AssetList struct {
+	Assets      []Asset      `json:"ASSETS"`
+	SkipControl *SkipControl `json:"SKIP-CONTROL,omitempty"`
+}
+
+// Asset is an interstitial in an AssetList. The duration is in seconds.
+type Asset struct {
+	URI      string  `json:"URI"`
+	Duration float64 `json:"DURATION"`
+}
+
+// SkipControl controls when the user may skip the interstitials in an
+// AssetList. The offset and duration are in seconds.
+type SkipControl struct {
+	Offset   float64 `json:"OFFSET"`
+	Duration float64 `json:"DURATION,omitempty"`
+	LabelID  string  `json:"LABEL-ID,omitempty"`
+}
+
+// Duration returns the sum of the asset durations
+func (a AssetList) Duration() (d time.Duration) {
+	for _, a := range a.Assets {
+		d += time.Duration(a.Duration * float64(time.Second))
+	}
+	return d
+}
+
+// LoadAssets fetches and decodes the asset list of the interstitial,
+// resolving its URI relative to the parent playlist
+func (c DateRange) LoadAssets(ctx context.Context, f Fetcher, parent string) (a AssetList, err error) {
+	if c.AssetList == "" {
+		return a, fmt.Errorf("hls: interstitial %s: no asset list", c.ID)
+	}
+	body, err := f.Fetch(ctx, pathof(parent, c.AssetList))
+	if err != nil {
+		return a, err
+	}
+	defer body.Close()
+	err = json.NewDecoder(body).Decode(&a)
+	return a, err
+}
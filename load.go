@@ -0,0 +1,166 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// HTTPFetcher is a Fetcher that makes GET requests with Client, or
+// http.DefaultClient if Client is nil. Responses other than 200 OK are
+// returned as errors.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+// Fetch fetches url
+func (h HTTPFetcher) Fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	c := h.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("hls: fetch %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Tree is a master playlist and the media playlists it references
+type Tree struct {
+	Master Master
+
+	// Media is keyed by the URL of the playlist, resolved relative to
+	// the master playlist. Look them up with Path:
+	//
+	//	t.Media[t.Master.Stream[0].Path(t.Master.URL)]
+	Media map[string]Media
+}
+
+// Loader loads a master playlist and every variant, I-frame and
+// rendition playlist it references
+type Loader struct {
+	Fetcher Fetcher // if nil, HTTPFetcher{} is used
+
+	// Limit is the maximum number of media playlists fetched at the
+	// same time. Zero means 4.
+	Limit int
+}
+
+// Load loads the master playlist at url with the given client, see Loader
+func Load(ctx context.Context, c *http.Client, url string) (*Tree, error) {
+	return Loader{Fetcher: HTTPFetcher{Client: c}}.Load(ctx, url)
+}
+
+// Load fetches the master playlist at url and the media playlists it
+// references. Playlists referenced more than once are only fetched once.
+// The URL of every playlist in the tree is set to the location it was
+// fetched from, and variables imported from the master playlist are
+// resolved. The first error cancels the remaining fetches.
+func (l Loader) Load(ctx context.Context, url string) (*Tree, error) {
+	f := l.Fetcher
+	if f == nil {
+		f = HTTPFetcher{}
+	}
+	limit := l.Limit
+	if limit <= 0 {
+		limit = 4
+	}
+
+	body, err := f.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{Master: Master{URL: url}, Media: map[string]Media{}}
+	err = t.Master.Decode(body)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("hls: %s: %w", url, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+		sem   = make(chan bool, limit)
+		seen  = map[string]bool{}
+	)
+	for _, u := range t.Master.children() {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			m, err := l.media(ctx, f, &t.Master, u)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if first == nil {
+					first = err
+					cancel()
+				}
+				return
+			}
+			t.Media[u] = m
+		}(u)
+	}
+	wg.Wait()
+	if first != nil {
+		return nil, first
+	}
+	return t, nil
+}
+
+func (l Loader) media(ctx context.Context, f Fetcher, parent *Master, url string) (Media, error) {
+	if err := ctx.Err(); err != nil {
+		return Media{}, err
+	}
+	body, err := f.Fetch(ctx, url)
+	if err != nil {
+		return Media{}, err
+	}
+	defer body.Close()
+	m := Media{URL: url}
+	if err := m.Decode(body); err != nil {
+		return m, fmt.Errorf("hls: %s: %w", url, err)
+	}
+	if len(m.Define) > 0 && !selfcontained(m.Define, m.URL) {
+		if err := m.Resolve(parent); err != nil {
+			return m, fmt.Errorf("hls: %s: %w", url, err)
+		}
+	}
+	return m, nil
+}
+
+// children returns the resolved URLs of the media playlists referenced
+// by m, in order
+func (m Master) children() (u []string) {
+	for _, s := range m.Stream {
+		u = append(u, s.Path(m.URL))
+	}
+	for _, s := range m.IFrame {
+		u = append(u, s.Path(m.URL))
+	}
+	for _, r := range m.Media {
+		if r.URI != "" {
+			u = append(u, r.Path(m.URL))
+		}
+	}
+	return u
+}
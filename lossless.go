@@ -0,0 +1,596 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/as/hls/m3u"
+)
+
+// Lossless mode
+//
+// A playlist decoded with DecodeLossless keeps its source text. When it's
+// encoded, the playlist is broken into elements: one for every header tag
+// and one for every segment (along with all of the segment's tags). The
+// elements are compared to the ones that were originally decoded, and only
+// the elements that changed are written in canonical form. Everything else,
+// including comments, blank lines, unknown tags and the tag order, is
+// copied from the source. Comments and unknown tags before a segment stay
+// with it when segments are moved, and are removed along with it.
+
+// DecodeLossless is like Decode, except that m retains the source text
+// of the playlist, and Encode only rewrites what changed since then.
+func (m *Media) DecodeLossless(r io.Reader) error {
+	src, t, err := readsource(r)
+	if err != nil {
+		return err
+	}
+	if ismaster(t) {
+		return ErrType
+	}
+	if err := m.DecodeTag(t...); err != nil {
+		return err
+	}
+	header := register(reflect.ValueOf(&m.MediaHeader), false)
+	file := register(reflect.ValueOf(&File{}), false)
+	last := -1
+	for i, t := range src.tag {
+		if t.Name == "EXTINF" {
+			last = i
+		}
+	}
+	// the segment of each tag, by the number of EXTINF tags before it
+	segof := make([]int, len(src.tag))
+	for i, n := 0, 0; i < len(src.tag); i++ {
+		segof[i] = n
+		if src.tag[i].Name == "EXTINF" {
+			n++
+		}
+	}
+	src.assign(m.elements(), func(i int, t m3u.Tag) (kind string, seg int) {
+		_, known := file.field[t.Name]
+		_, head := header.field[t.Name]
+		switch {
+		case head:
+			return t.Name, -1
+		case i > last && t.Name == "EXT-X-PART":
+			return "$part", -1
+		case i <= last && (known || extratag[t.Name] != nil):
+			return "$file", segof[i]
+		}
+		return "", -1
+	})
+	m.src = src
+	return nil
+}
+
+// DecodeLossless is like Decode, except that m retains the source text
+// of the playlist, and Encode only rewrites what changed since then.
+func (m *Master) DecodeLossless(r io.Reader) error {
+	src, t, err := readsource(r)
+	if err != nil {
+		return err
+	}
+	if !ismaster(t) {
+		return ErrType
+	}
+	if err := m.DecodeTag(t...); err != nil {
+		return err
+	}
+	sym := register(reflect.ValueOf(m), false)
+	src.assign(m.elements(), func(i int, t m3u.Tag) (string, int) {
+		if _, ok := sym.field[t.Name]; ok {
+			return t.Name, -1
+		}
+		return "", -1
+	})
+	m.src = src
+	return nil
+}
+
+// element is a header tag, or a segment and its tags
+type element struct {
+	kind string
+	text string // canonical text
+	cmp  string // compared to detect changes, usually the same as text
+}
+
+// kinds returns the kinds of element in canonical order
+func (m Media) kinds() (k []string) {
+	names := register(reflect.ValueOf(&m.MediaHeader), false).names
+	for _, l := range names {
+		if !trailer[l.name] {
+			k = append(k, l.name)
+		}
+	}
+	k = append(k, "$file", "$part")
+	for _, l := range names {
+		if trailer[l.name] {
+			k = append(k, l.name)
+		}
+	}
+	return k
+}
+
+// elements returns the playlist's elements in canonical order
+func (m Media) elements() (e []element) {
+	header, _ := marshalTag0(m.MediaHeader)
+	for _, t := range header {
+		if !trailer[t.Name] {
+			e = append(e, element{kind: t.Name, text: t.String(), cmp: t.String()})
+		}
+	}
+	for i, f := range stripsticky(m) {
+		tags, _ := marshalTag0(f)
+		text := join(tags)
+
+		// the segment's source is only valid if the EXT-X-MAP and
+		// EXT-X-KEY state it inherits didn't change
+		state, _ := marshalTag0(File{Map: m.File[i].Map, Key: m.File[i].Key})
+		e = append(e, element{kind: "$file", text: text, cmp: text + "\x00" + join(state)})
+	}
+	for _, t := range marshalPart(m.Part...) {
+		e = append(e, element{kind: "$part", text: t.String(), cmp: t.String()})
+	}
+	for _, t := range header {
+		if trailer[t.Name] {
+			e = append(e, element{kind: t.Name, text: t.String(), cmp: t.String()})
+		}
+	}
+	return e
+}
+
+// kinds returns the kinds of element in canonical order
+func (m Master) kinds() (k []string) {
+	for _, l := range register(reflect.ValueOf(&m), false).names {
+		k = append(k, l.name)
+	}
+	return k
+}
+
+// elements returns the playlist's elements in canonical order
+func (m Master) elements() (e []element) {
+	tags, _ := marshalTag0(m)
+	for _, t := range tags {
+		e = append(e, element{kind: t.Name, text: t.String(), cmp: t.String()})
+	}
+	return e
+}
+
+func join(t []m3u.Tag) string {
+	s := make([]string, len(t))
+	for i := range t {
+		s[i] = t[i].String()
+	}
+	return strings.Join(s, "\n")
+}
+
+// source is the text of a playlist decoded in lossless mode
+type source struct {
+	line []string
+	eol  bool // the last line ends with a newline
+
+	tag    []m3u.Tag
+	tagpos []int // line of each tag
+
+	elem []element // elements as decoded
+	own  []int     // element that owns each line, or -1
+	free []bool    // line is a comment, blank line, or unknown tag
+}
+
+// readsource reads the source text and tokenizes it exactly
+// like m3u.Parse, but remembers which line each tag came from
+func readsource(r io.Reader) (*source, []m3u.Tag, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &source{eol: bytes.HasSuffix(data, []byte("\n"))}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Split(func(data []byte, eof bool) (int, []byte, error) {
+		// like bufio.ScanLines, but keeps the carriage returns
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if eof && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	uri := map[int]int{} // line of a uri -> the tag it belongs to
+	wait := -1           // tag waiting for its uri, see m3u.lex.Next
+	for sc.Scan() {
+		line := sc.Text()
+		s.line = append(s.line, line)
+		text := strings.TrimRight(line, "\r")
+		ws := len(text) - len(strings.TrimLeft(text, " \t"))
+		switch {
+		case ws+1 >= len(text):
+			// the lexer ignores these
+		case text[ws] == '#':
+			t, _ := m3u.Parse(strings.NewReader(text))
+			if len(t) > 0 {
+				s.tag = append(s.tag, t[0])
+				s.tagpos = append(s.tagpos, len(s.line)-1)
+				if t[0].Name == "EXTINF" || t[0].Name == "EXT-X-STREAM-INF" {
+					wait = len(s.tag) - 1
+				}
+			}
+		case len(s.tag) > 0:
+			n := len(s.tag) - 1
+			if wait >= 0 && wait < n {
+				// the tags between the uri and the tag it
+				// belongs to go before the tag, like the lexer
+				t, pos := s.tag[wait], s.tagpos[wait]
+				copy(s.tag[wait:], s.tag[wait+1:])
+				copy(s.tagpos[wait:], s.tagpos[wait+1:])
+				s.tag[n], s.tagpos[n] = t, pos
+			}
+			wait = -1
+			s.tag[n].Line = append(s.tag[n].Line, text[ws:])
+			uri[len(s.line)-1] = n
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.own = make([]int, len(s.line))
+	s.free = make([]bool, len(s.line))
+	for i := range s.own {
+		s.own[i] = -1
+		s.free[i] = true
+	}
+	for l, t := range uri {
+		s.own[l] = -(t + 2) // resolved to an element by assign
+	}
+	return s, s.tag, nil
+}
+
+// assign assigns every line of the source to one of the decoded elements.
+// The classify func returns the kind of element each tag belongs to, and
+// for segments, the index of the segment. Tags of an unknown kind, and
+// comments and blank lines, are free. Free lines between two segments
+// belong to the second one, the rest don't belong to anything.
+func (s *source) assign(elem []element, classify func(i int, t m3u.Tag) (kind string, seg int)) {
+	s.elem = elem
+	index := map[string][]int{}
+	for i, e := range elem {
+		index[e.kind] = append(index[e.kind], i)
+	}
+	lookup := func(kind string, n int) int {
+		list := index[kind]
+		if len(list) == 0 {
+			// the tag has a zero value, so it isn't in canonical
+			// form, but it still needs an owner in case it changes
+			s.elem = append(s.elem, element{kind: kind})
+			list = []int{len(s.elem) - 1}
+			index[kind] = list
+		}
+		if n >= len(list) {
+			n = len(list) - 1
+		}
+		return list[n]
+	}
+	at := map[int]int{}
+	for i, l := range s.tagpos {
+		at[l] = i
+	}
+	tagowner := make([]int, len(s.tag))
+	seen := map[string]int{}
+	var pending []int
+	files := false
+	for l := range s.line {
+		if own := s.own[l]; own < -1 {
+			// a uri belongs to the tag before it
+			t := -(own + 2)
+			s.own[l], s.free[l] = tagowner[t], tagowner[t] < 0
+			if s.free[l] {
+				pending = append(pending, l)
+			}
+			continue
+		}
+		i, ok := at[l]
+		if !ok {
+			pending = append(pending, l)
+			continue
+		}
+		kind, seg := classify(i, s.tag[i])
+		tagowner[i] = -1
+		switch kind {
+		case "":
+			pending = append(pending, l)
+			continue
+		case "$file":
+			tagowner[i] = lookup(kind, seg)
+			if files {
+				for _, p := range pending {
+					s.own[p] = tagowner[i]
+				}
+			}
+			pending = pending[:0]
+			files = true
+		default:
+			tagowner[i] = lookup(kind, seen[kind])
+			seen[kind]++
+		}
+		s.own[l], s.free[l] = tagowner[i], false
+	}
+}
+
+// encode writes the playlist with the given elements. Unchanged elements
+// are copied from the source. Kinds is the canonical order of the kinds of
+// element, which determines where elements of a new kind are written.
+func (s *source) encode(w io.Writer, elem []element, kinds []string) error {
+	var (
+		oldby = map[string][]int{}
+		newby = map[string][]int{}
+		rank  = map[string]int{}
+	)
+	for i, k := range kinds {
+		rank[k] = i
+	}
+	for i, e := range elem {
+		newby[e.kind] = append(newby[e.kind], i)
+	}
+	owned := make([]bool, len(s.elem))
+	for _, e := range s.own {
+		if e >= 0 {
+			owned[e] = true
+		}
+	}
+	for i, e := range s.elem {
+		// an element that isn't in the source can't be copied
+		// from it, so it's the same as a new element
+		if owned[i] {
+			oldby[e.kind] = append(oldby[e.kind], i)
+		}
+	}
+
+	var (
+		keep   = make([]bool, len(s.elem))
+		repl   = make([]int, len(s.elem)) // new element replacing the old one, or -1
+		after  = map[int][]int{}          // new elements inserted after an old one
+		before = map[string][]int{}       // new elements inserted before the first old one
+		fresh  []int                      // new elements of a kind not in the source
+	)
+	for i := range repl {
+		repl[i] = -1
+	}
+	for _, kind := range kinds {
+		o, n := oldby[kind], newby[kind]
+		if len(o)+len(n) == 0 {
+			continue
+		}
+		if len(o) == 0 {
+			fresh = append(fresh, n...)
+			continue
+		}
+		if len(n) == 0 && s.elem[o[0]].text == "" {
+			// still zero
+			keep[o[0]] = true
+			continue
+		}
+		a, b := make([]string, len(o)), make([]string, len(n))
+		for i := range o {
+			a[i] = s.elem[o[i]].cmp
+		}
+		for i := range n {
+			b[i] = elem[n[i]].cmp
+		}
+		match := lcs(a, b)
+		last := -1
+		for i, j := 0, 0; i <= len(o); {
+			ni := i
+			for ni < len(o) && match[ni] < 0 {
+				ni++
+			}
+			nj := len(n)
+			if ni < len(o) {
+				nj = match[ni]
+			}
+			// the changed elements are paired up in order, except
+			// at the start of the list, where elements are usually
+			// removed (as in a sliding window) rather than added
+			skip := 0
+			if i == 0 && ni-i > nj-j {
+				skip = (ni - i) - (nj - j)
+			}
+			t := 0
+			for ; i+skip+t < ni && j+t < nj; t++ {
+				repl[o[i+skip+t]] = n[j+t]
+				last = o[i+skip+t]
+			}
+			for ; j+t < nj; t++ {
+				if last < 0 {
+					before[kind] = append(before[kind], n[j+t])
+				} else {
+					after[last] = append(after[last], n[j+t])
+				}
+			}
+			if ni == len(o) {
+				break
+			}
+			keep[o[ni]] = true
+			last = o[ni]
+			i, j = ni+1, nj+1
+		}
+	}
+
+	// the free lines of a segment go wherever the segment goes: to the
+	// new element that is identical to it, or else the one replacing it
+	freeof := map[int][]string{}
+	for l, e := range s.own {
+		if e >= 0 && s.free[l] && !keep[e] {
+			freeof[e] = append(freeof[e], s.line[l])
+		}
+	}
+	var (
+		carry   = map[int]int{} // new element -> old element whose free lines it carries
+		claimed = map[int]bool{}
+		moved   = map[string][]int{}
+	)
+	for i := range s.elem {
+		if len(freeof[i]) > 0 {
+			moved[s.elem[i].cmp] = append(moved[s.elem[i].cmp], i)
+		}
+	}
+	for j, e := range elem {
+		if list := moved[e.cmp]; len(list) > 0 {
+			carry[j], claimed[list[0]] = list[0], true
+			moved[e.cmp] = list[1:]
+		}
+	}
+	for i, j := range repl {
+		if _, ok := carry[j]; j >= 0 && !ok && !claimed[i] {
+			carry[j], claimed[i] = i, true
+		}
+	}
+
+	end := make([]int, len(s.elem))
+	for l, e := range s.own {
+		if e >= 0 {
+			end[e] = l
+		}
+	}
+	out := bufio.NewWriter(w)
+	nl := false
+	put := func(text string) {
+		if nl {
+			out.WriteByte('\n')
+		}
+		out.WriteString(text)
+		nl = true
+	}
+	putnew := func(j int) {
+		if i, ok := carry[j]; ok {
+			for _, line := range freeof[i] {
+				put(line)
+			}
+		}
+		put(elem[j].text)
+	}
+	puts := func(list []int) {
+		for _, j := range list {
+			putnew(j)
+		}
+	}
+	done := make([]bool, len(s.elem))
+	started := map[string]bool{}
+	for l, line := range s.line {
+		e := s.own[l]
+		if e < 0 {
+			put(line)
+			continue
+		}
+		kind := s.elem[e].kind
+		for len(fresh) > 0 && rank[elem[fresh[0]].kind] < rank[kind] {
+			putnew(fresh[0])
+			fresh = fresh[1:]
+		}
+		if !started[kind] {
+			started[kind] = true
+			puts(before[kind])
+		}
+		switch {
+		case keep[e]:
+			put(line)
+		case repl[e] >= 0 && !s.free[l] && !done[e]:
+			putnew(repl[e])
+			done[e] = true
+		}
+		if l == end[e] {
+			puts(after[e])
+		}
+	}
+	for _, kind := range kinds {
+		if !started[kind] {
+			puts(before[kind])
+		}
+	}
+	puts(fresh)
+	if nl && (s.eol || len(s.line) == 0) {
+		out.WriteByte('\n')
+	}
+	return out.Flush()
+}
+
+// lcs returns the index in b of each element of a in their longest
+// common subsequence, or -1 if the element isn't part of it. It uses
+// Myers' algorithm, and gives up if a and b are too far apart.
+func lcs(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	// common prefix and suffix
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		match[p] = p
+		p++
+	}
+	q := 0
+	for q < len(a)-p && q < len(b)-p && a[len(a)-1-q] == b[len(b)-1-q] {
+		match[len(a)-1-q] = len(b) - 1 - q
+		q++
+	}
+	a, b = a[p:len(a)-q], b[p:len(b)-q]
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return match
+	}
+	const limit = 1000
+	max := n + m
+	if max > 2*limit {
+		max = 2 * limit
+	}
+	v := make([]int, 2*max+3)
+	off := max + 1
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		// snapshot v[-d:d+1]
+		trace = append(trace, append([]int{}, v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x < n || y < m {
+				continue
+			}
+			// backtrack through the snapshots
+			for d := d; d > 0; d-- {
+				prev := trace[d]
+				at := func(k int) int { return prev[k+d] }
+				k := x - y
+				pk := k - 1
+				if k == -d || k != d && at(k-1) < at(k+1) {
+					pk = k + 1
+				}
+				px := at(pk)
+				py := px - pk
+				for x > px && y > py {
+					x, y = x-1, y-1
+					match[p+x] = p + y
+				}
+				x, y = px, py
+			}
+			for x > 0 && y > 0 {
+				x, y = x-1, y-1
+				match[p+x] = p + y
+			}
+			return match
+		}
+	}
+	// too different, treat everything in the middle as changed
+	return match
+}
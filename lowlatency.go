@@ -0,0 +1,84 @@
+package hls
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/as/hls/m3u"
+)
+
+// Low-Latency HLS extensions, located here:
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis#section-4.4.4.9
+//
+// A partial segment (EXT-X-PART) is a subset of a parent segment. Completed
+// parts precede the EXTINF tag of their parent and are stored in File.Part.
+// Parts of the segment currently being produced have no EXTINF tag yet, so
+// they trail the last full segment and are stored in Media.Part.
+
+// Part is a partial segment
+type Part struct {
+	Duration    time.Duration `hls:"DURATION" json:",omitempty"`
+	URI         string        `hls:"URI" json:",omitempty"`
+	Independent bool          `hls:"INDEPENDENT,omitempty" json:",omitempty"`
+	Range       string        `hls:"BYTERANGE,omitempty" json:",omitempty"`
+	Gap         bool          `hls:"GAP,omitempty" json:",omitempty"`
+}
+
+// Path is Path
+func (p Part) Path(parent string) string {
+	return pathof(parent, p.URI)
+}
+
+// PartInf is the EXT-X-PART-INF tag. Target is the maximum
+// duration of any partial segment in the playlist.
+type PartInf struct {
+	Target time.Duration `hls:"PART-TARGET" json:",omitempty"`
+}
+
+// ServerControl is the EXT-X-SERVER-CONTROL tag. It advertises the
+// delivery directives supported by the server.
+type ServerControl struct {
+	CanBlockReload    bool          `hls:"CAN-BLOCK-RELOAD,omitempty" json:",omitempty"`
+	CanSkipUntil      time.Duration `hls:"CAN-SKIP-UNTIL,omitempty" json:",omitempty"`
+	CanSkipDateRanges bool          `hls:"CAN-SKIP-DATERANGES,omitempty" json:",omitempty"`
+	HoldBack          time.Duration `hls:"HOLD-BACK,omitempty" json:",omitempty"`
+	PartHoldBack      time.Duration `hls:"PART-HOLD-BACK,omitempty" json:",omitempty"`
+}
+
+// PreloadHint is the EXT-X-PRELOAD-HINT tag. It tells the client about a
+// resource (a PART or a MAP) that the server is about to make available.
+type PreloadHint struct {
+	Type   string `hls:"TYPE,noquote" json:",omitempty"`
+	URI    string `hls:"URI" json:",omitempty"`
+	Start  int    `hls:"BYTERANGE-START,omitempty" json:",omitempty"`
+	Length int    `hls:"BYTERANGE-LENGTH,omitempty" json:",omitempty"`
+}
+
+// Path is Path
+func (p PreloadHint) Path(parent string) string {
+	return pathof(parent, p.URI)
+}
+
+// RenditionReport is the EXT-X-RENDITION-REPORT tag. LastMSN and LastPart
+// are nil if their attributes are absent, since zero is a valid value for both.
+type RenditionReport struct {
+	URI      string `hls:"URI" json:",omitempty"`
+	LastMSN  *int   `hls:"LAST-MSN,omitempty" json:",omitempty"`
+	LastPart *int   `hls:"LAST-PART,omitempty" json:",omitempty"`
+}
+
+// Path is Path
+func (r RenditionReport) Path(parent string) string {
+	return pathof(parent, r.URI)
+}
+
+// marshalPart encodes the list of parts as a list of EXT-X-PART tags
+func marshalPart(p ...Part) (t []m3u.Tag) {
+	for _, p := range p {
+		tag := m3u.Tag{Name: "EXT-X-PART"}
+		settag(reflect.ValueOf(p), &tag, false)
+		t = append(t, tag)
+	}
+	return t
+}
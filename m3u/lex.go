@@ -12,11 +12,42 @@ func Parse(r io.Reader) (t []Tag, err error) {
 	return newlex(r).Parse()
 }
 
+// ParseStrict is like Parse, except it stops at the first malformed
+// line and returns a *SyntaxError describing it. Every tag it returns
+// records its position in Tag.Pos.
+func ParseStrict(r io.Reader) (t []Tag, err error) {
+	return NewStrict(r).Parse()
+}
+
+// SyntaxError is a malformed line found in strict mode
+type SyntaxError struct {
+	Line int    // line number, starting at 1
+	Col  int    // byte offset in the line, starting at 1
+	Text string // the offending text
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("m3u: line %d col %d: %s: %q", e.Line, e.Col, e.Msg, e.Text)
+}
+
 type lex struct {
-	line []byte
-	i, j int
-	sc   *bufio.Scanner
-	tag  Tag
+	line    []byte
+	i, j    int
+	sc      *bufio.Scanner
+	tag     Tag
+	pending bool // tag has been lexed, but not returned by Next
+
+	// wait is an EXTINF or EXT-X-STREAM-INF tag that has no URI
+	// line yet. It's held back along with the tags after it, which
+	// apply to the same URI, until the URI is reached.
+	wait *Tag
+	held []Tag
+	out  []Tag // tags ready to be returned by Next
+
+	strict bool
+	n      int // line number
+	err    error
 }
 
 func newlex(r io.Reader) *lex {
@@ -29,29 +60,115 @@ func New(r io.Reader) *lex {
 	return newlex(r)
 }
 
+// NewStrict is like New, but the lexer operates in strict mode. See ParseStrict.
+func NewStrict(r io.Reader) *lex {
+	l := newlex(r)
+	l.strict = true
+	return l
+}
+
 func (l *lex) Parse() (t []Tag, err error) {
-	for l.sc.Scan() {
+	for {
+		tag, err := l.Next()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return t, err
+		}
+		t = append(t, tag)
+	}
+}
+
+// Next returns the next tag in the input, along with the lines of
+// associated data that follow it (the URI after an EXTINF tag). It
+// returns io.EOF when there are no more tags.
+//
+// A tag is only returned once the next tag, or the end of the input,
+// has been reached. The tags between an EXTINF or EXT-X-STREAM-INF tag
+// and its URI line, such as EXT-X-BYTERANGE, are returned before it,
+// since they apply to the same URI.
+func (l *lex) Next() (Tag, error) {
+	for len(l.out) == 0 {
+		if l.err != nil {
+			return Tag{}, l.err
+		}
+		if !l.sc.Scan() {
+			if err := l.sc.Err(); err != nil {
+				return Tag{}, err
+			}
+			if l.pending {
+				l.pending = false
+				l.finish(l.tag)
+			}
+			l.flush()
+			if len(l.out) == 0 {
+				return Tag{}, io.EOF
+			}
+			break
+		}
+		l.n++
 		l.line = l.sc.Bytes()
 		l.i, l.j = 0, 0
 		l.whitespace()
 		switch l.peek() {
 		case '#':
+			prev, pending := l.tag, l.pending
 			if l.lexTag() {
-				t = append(t, l.tag)
+				if l.err != nil {
+					return Tag{}, l.err
+				}
+				if pending {
+					l.finish(prev)
+				}
+				l.pending = true
 			}
 		case 0:
 		default:
-			l.tag.Line = append(l.tag.Line, string(l.line[l.i:]))
-			if len(t) > 0 {
-				t[len(t)-1] = l.tag
+			uri := string(l.line[l.i:])
+			switch {
+			case l.wait != nil:
+				if l.pending {
+					l.pending = false
+					l.finish(l.tag)
+				}
+				l.wait.Line = append(l.wait.Line, uri)
+				l.held = append(l.held, *l.wait)
+				l.wait = nil
+				l.out, l.held = append(l.out, l.held...), nil
+			case l.strict && !l.uri():
+				return Tag{}, l.errorf(l.i, len(l.line), "stray URI line")
+			default:
+				l.tag.Line = append(l.tag.Line, uri)
 			}
 		}
 	}
-	err = l.sc.Err()
-	if err == io.EOF {
-		err = nil
+	t := l.out[0]
+	l.out = l.out[1:]
+	return t, nil
+}
+
+// finish moves a lexed tag to the output, or holds it back if it's
+// waiting for a URI line, or follows one that is
+func (l *lex) finish(t Tag) {
+	switch {
+	case urinext(t.Name) && len(t.Line) == 0:
+		l.flush()
+		l.wait = &t
+	case l.wait != nil:
+		l.held = append(l.held, t)
+	default:
+		l.out = append(l.out, t)
+	}
+}
+
+// flush outputs a tag that never got its URI line, in its original order
+func (l *lex) flush() {
+	if l.wait != nil {
+		l.out = append(l.out, *l.wait)
+		l.out, l.held = append(l.out, l.held...), nil
+		l.wait = nil
 	}
-	return t, err
 }
 
 func (s *lex) lexTag() bool {
@@ -59,8 +176,15 @@ func (s *lex) lexTag() bool {
 		return false
 	}
 	s.tag = Tag{}
+	if s.strict {
+		s.tag.Pos = Pos{Line: s.n, Col: s.i}
+	}
 	s.until(':')
 	s.tag.Name = s.token()
+	if s.strict && s.tag.Name == "" {
+		s.errorf(s.i, len(s.line), "missing tag name")
+		return true
+	}
 	s.lexAttr()
 	return true
 }
@@ -72,15 +196,22 @@ func (s *lex) lexAttr() bool {
 
 	delims := ",="
 	for s.untilAny(delims) || s.i < s.j {
+		start := s.i
 		f := Value{V: s.token()}
-		if !s.lexAttrValue(&f) {
+		if !s.lexAttrValue(&f, start) {
 			s.tag.Arg = append(s.tag.Arg, f)
 			// after we encounter the first keyless field, stop looking
 			// for equal signs, since they might be part of the url
 			// in EXTINF tags
 			delims = ","
 		}
-		if s.skip() != ',' {
+		if s.err != nil {
+			return false
+		}
+		if c := s.skip(); c != ',' {
+			if s.strict && c != 0 && strings.TrimSpace(string(s.line[s.i-1:])) != "" {
+				s.errorf(s.i-1, len(s.line), "unexpected text after attribute")
+			}
 			break
 		}
 		//s.whitespace()
@@ -92,13 +223,16 @@ func (s *lex) debug(fm string, a ...any) {
 	fmt.Fprintf(os.Stderr, fm, a...)
 }
 
-func (s *lex) lexAttrValue(key *Value) bool {
+func (s *lex) lexAttrValue(key *Value, start int) bool {
 	if !s.ignore('=') {
 		return false
 	}
 	f := Value{}
 	if s.ignore('"') {
-		s.until('"')
+		if !s.until('"') && s.strict {
+			s.errorf(s.i-1, len(s.line), "unterminated quoted string")
+			return true
+		}
 		f.V = s.token()
 		f.Quote = true
 		s.ignore('"')
@@ -113,6 +247,10 @@ func (s *lex) lexAttrValue(key *Value) bool {
 		key.V += "=" + f.V
 		return false
 	}
+	if s.strict && !attrname(key.V) {
+		s.errorf(start, start+len(key.V), "invalid attribute name")
+		return true
+	}
 	s.tag.Keys = append(s.tag.Keys, key.V)
 	if s.tag.Flag == nil {
 		s.tag.Flag = map[string]Value{}
@@ -121,6 +259,37 @@ func (s *lex) lexAttrValue(key *Value) bool {
 	return true
 }
 
+// uri returns true if the current line is the URI of the last tag. Only
+// EXTINF and EXT-X-STREAM-INF are followed by a URI line, and only by one.
+func (s *lex) uri() bool {
+	return urinext(s.tag.Name) && s.pending && len(s.tag.Line) == 0
+}
+
+// urinext returns true if the tag is followed by a URI line
+func urinext(name string) bool {
+	return name == "EXTINF" || name == "EXT-X-STREAM-INF"
+}
+
+// attrname returns true if name is a valid AttributeName: one
+// or more of the characters [A-Z], [0-9] and '-'
+func attrname(name string) bool {
+	for _, c := range []byte(name) {
+		if !('A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// errorf records a syntax error for line[i:j] and returns it
+func (s *lex) errorf(i, j int, msg string) error {
+	if j > len(s.line) {
+		j = len(s.line)
+	}
+	s.err = &SyntaxError{Line: s.n, Col: i + 1, Text: string(s.line[i:j]), Msg: msg}
+	return s.err
+}
+
 func (s *lex) ok() bool {
 	return s.j <= len(s.line)
 }
@@ -11,6 +11,16 @@ type Tag struct {
 	Keys []string
 	Arg  []Value
 	Line []string
+
+	// Pos is the position of the tag in the input. It's
+	// only recorded by the lexer in strict mode.
+	Pos Pos
+}
+
+// Pos is a position in the input. The zero value means unknown.
+type Pos struct {
+	Line int // starting at 1
+	Col  int // starting at 1
 }
 
 func (t Tag) Value(key string) (val string) {
@@ -87,7 +97,9 @@ type Value struct {
 
 func (v Value) String() string {
 	if v.Quote {
-		return fmt.Sprintf("%q", v.V)
+		// quoted-strings have no escape sequences; they may contain
+		// anything except a double quote, CR and LF
+		return `"` + v.V + `"`
 	}
 	return v.V
 }
@@ -59,6 +59,59 @@ func TestLexHeader(t *testing.T) {
 	}
 }
 
+func TestParseStrict(t *testing.T) {
+	tag, err := ParseStrict(strings.NewReader("#EXTM3U\n\n  #EXTINF:10.0,\nfile.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Tag{Name: "EXTINF", Arg: []Value{{V: "10.0"}}, Line: []string{"file.ts"}, Pos: Pos{Line: 3, Col: 3}}
+	if !reflect.DeepEqual(want, tag[1]) {
+		t.Fatalf("mismatch:\n\t\thave: %#v\n\t\twant: %#v", tag[1], want)
+	}
+
+	for _, tc := range []struct {
+		in   string
+		want SyntaxError
+	}{
+		{"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin", SyntaxError{Line: 2, Col: 31, Text: `"key.bin`, Msg: "unterminated quoted string"}},
+		{"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"x", SyntaxError{Line: 2, Col: 40, Text: "x", Msg: "unexpected text after attribute"}},
+		{"#EXTM3U\n#EXT-X-KEY:method=AES-128", SyntaxError{Line: 2, Col: 12, Text: "method", Msg: "invalid attribute name"}},
+		{"#EXTM3U\nfile.ts", SyntaxError{Line: 2, Col: 1, Text: "file.ts", Msg: "stray URI line"}},
+		{"#EXTM3U\n#EXTINF:1,\n1.ts\n2.ts", SyntaxError{Line: 4, Col: 1, Text: "2.ts", Msg: "stray URI line"}},
+		{"#EXTM3U\n#:x", SyntaxError{Line: 2, Col: 2, Text: ":x", Msg: "missing tag name"}},
+	} {
+		_, err := ParseStrict(strings.NewReader(tc.in))
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("%q: have %v, want *SyntaxError", tc.in, err)
+		}
+		if *se != tc.want {
+			t.Fatalf("%q:\n\t\thave: %+v\n\t\twant: %+v", tc.in, *se, tc.want)
+		}
+
+		// the default mode is resilient
+		if _, err := Parse(strings.NewReader(tc.in)); err != nil {
+			t.Fatalf("%q: lenient parse: %v", tc.in, err)
+		}
+	}
+
+	// the tags between EXTINF and its URI are returned before it
+	tag, err = ParseStrict(strings.NewReader("#EXTM3U\n#EXTINF:4,\n#EXT-X-BYTERANGE:100@0\n#EXT-X-DISCONTINUITY\nseg.ts\n#EXTINF:4,\nseg.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, t := range tag {
+		names = append(names, t.Name)
+	}
+	if h, w := names, []string{"EXTM3U", "EXT-X-BYTERANGE", "EXT-X-DISCONTINUITY", "EXTINF", "EXTINF"}; !reflect.DeepEqual(h, w) {
+		t.Fatalf("order:\n\t\thave: %q\n\t\twant: %q", h, w)
+	}
+	if h := tag[3]; len(h.Line) != 1 || h.Line[0] != "seg.ts" || h.Pos.Line != 2 {
+		t.Fatalf("bad EXTINF: %#v", h)
+	}
+}
+
 func TestParse(t *testing.T) {
 	var raw = `
 #EXTM3U
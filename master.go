@@ -1,6 +1,8 @@
 package hls
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 	"io"
@@ -12,15 +14,21 @@ import (
 // Master is a master playlist. It contains a list of streams (variants) and
 // media information associated by group id. By convention, the master playlist is immutable.
 type Master struct {
-	M3U         bool         `hls:"EXTM3U" json:",omitempty"`
-	Version     int          `hls:"EXT-X-VERSION" json:",omitempty"`
-	Independent bool         `hls:"EXT-X-INDEPENDENT-SEGMENTS,omitempty" json:",omitempty"`
-	Steering    Steering     `hls:"EXT-X-CONTENT-STEERING,omitempty" json:",omitempty"`
-	Media       []MediaInfo  `hls:"EXT-X-MEDIA,aggr,omitempty" json:",omitempty"`
-	Stream      []StreamInfo `hls:"EXT-X-STREAM-INF,aggr,omitempty" json:",omitempty"`
-	IFrame      []StreamInfo `hls:"EXT-X-I-FRAME-STREAM-INF,aggr,omitempty" json:",omitempty"`
+	M3U         bool          `hls:"EXTM3U" json:",omitempty"`
+	Version     int           `hls:"EXT-X-VERSION,omitempty" json:",omitempty"`
+	Independent bool          `hls:"EXT-X-INDEPENDENT-SEGMENTS,omitempty" json:",omitempty"`
+	Define      []Define      `hls:"EXT-X-DEFINE,aggr,omitempty" json:",omitempty"`
+	Steering    Steering      `hls:"EXT-X-CONTENT-STEERING,omitempty" json:",omitempty"`
+	SessionData []SessionData `hls:"EXT-X-SESSION-DATA,aggr,omitempty" json:",omitempty"`
+	SessionKey  []Key         `hls:"EXT-X-SESSION-KEY,aggr,omitempty" json:",omitempty"`
+	Media       []MediaInfo   `hls:"EXT-X-MEDIA,aggr,omitempty" json:",omitempty"`
+	Stream      []StreamInfo  `hls:"EXT-X-STREAM-INF,aggr,omitempty" json:",omitempty"`
+	IFrame      []StreamInfo  `hls:"EXT-X-I-FRAME-STREAM-INF,aggr,omitempty" json:",omitempty"`
 
 	URL string `json:",omitempty"`
+
+	src      *source // see DecodeLossless
+	declared int     // the EXT-X-VERSION m was decoded with, see setversion
 }
 
 // Decode decodes the master playlist into m.
@@ -35,22 +43,70 @@ func (m *Master) Decode(r io.Reader) error {
 	return m.DecodeTag(t...)
 }
 
+// DecodeStrict is like Decode, except it fails on the first malformed
+// line or value. The error is either a *m3u.SyntaxError or a *ValueError,
+// both of which carry the line number.
+func (m *Master) DecodeStrict(r io.Reader) error {
+	t, master, err := DecodeStrict(r)
+	if err != nil {
+		return err
+	}
+	if !master {
+		return ErrType
+	}
+	return m.decode(unmarshalStrict, t...)
+}
+
+// DecodeTag decodes the list of tags as a master playlist. Variables
+// defined with EXT-X-DEFINE are resolved if they only depend on the
+// playlist and its URL, otherwise they must be resolved with Resolve.
+// If the playlist has no EXT-X-DEFINE tags, a variable reference is an
+// error wrapping ErrVar.
 func (m *Master) DecodeTag(t ...m3u.Tag) error {
-	if err := unmarshalTag0(m, t...); err != nil {
+	return m.decode(unmarshalTag0, t...)
+}
+
+func (m *Master) decode(unmarshal func(interface{}, ...m3u.Tag) error, t ...m3u.Tag) error {
+	if err := unmarshal(m, t...); err != nil {
 		return err
 	}
+	m.declared = m.Version
 	if !m.M3U {
 		return ErrHeader
 	}
+	switch {
+	case len(m.Define) == 0:
+		if err := undefined(t...); err != nil {
+			return err
+		}
+	case selfcontained(m.Define, m.URL):
+		if err := m.Resolve(); err != nil {
+			return err
+		}
+	}
 	if len(m.Stream) == 0 {
 		return ErrEmpty
 	}
 	return nil
 }
 
-// Encode encodes the master
+// Encode encodes the master playlist. If m was decoded with DecodeLossless,
+// only the parts of the playlist that changed are rewritten.
+//
+// If m.Version is zero, or still the version the playlist was decoded with,
+// it's raised to the lowest version compatible with the features used by
+// the playlist. If the caller set it any lower, Encode returns ErrVersion.
 func (m Master) Encode(w io.Writer) (err error) {
+	if err := m.setversion(); err != nil {
+		return err
+	}
 	tags, err := m.EncodeTag()
+	if err != nil {
+		return err
+	}
+	if m.src != nil {
+		return m.src.encode(w, m.elements(), m.kinds())
+	}
 	for _, t := range tags {
 		fmt.Fprintln(w, t)
 	}
@@ -58,10 +114,13 @@ func (m Master) Encode(w io.Writer) (err error) {
 }
 
 func (m Master) EncodeTag() (t []m3u.Tag, err error) {
+	if err := m.setversion(); err != nil {
+		return nil, err
+	}
 	if t, err = marshalTag0(m); err != nil {
 		return t, err
 	}
-	return t, err
+	return t, unquotable(t)
 }
 
 // Len returns the number of variant streams
@@ -74,6 +133,39 @@ type Steering struct {
 	Pathway string `hls:"PATHWAY-ID,omitempty" json:",omitempty"`
 }
 
+// SessionData is the EXT-X-SESSION-DATA tag. It carries arbitrary session
+// data, either inline in Value, or as a resource referenced by URI. The
+// resource is JSON unless Format is RAW.
+type SessionData struct {
+	ID     string `hls:"DATA-ID" json:",omitempty"`
+	Value  string `hls:"VALUE,omitempty" json:",omitempty"`
+	URI    string `hls:"URI,omitempty" json:",omitempty"`
+	Format string `hls:"FORMAT,noquote,omitempty" json:",omitempty"`
+	Lang   string `hls:"LANGUAGE,omitempty" json:",omitempty"`
+}
+
+// Path is Path
+func (s SessionData) Path(parent string) string {
+	return pathof(parent, s.URI)
+}
+
+// Load fetches the JSON resource referenced by s.URI relative to parent,
+// and decodes it into v.
+func (s SessionData) Load(ctx context.Context, f Fetcher, parent string, v any) error {
+	if s.URI == "" {
+		return fmt.Errorf("hls: session data %s: no uri", s.ID)
+	}
+	if s.Format != "" && s.Format != "JSON" {
+		return fmt.Errorf("hls: session data %s: format %s is not JSON", s.ID, s.Format)
+	}
+	body, err := f.Fetch(ctx, s.Path(parent))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
 type MediaInfo struct {
 	Type       string   `hls:"TYPE,noquote,omitempty" json:",omitempty"`
 	Group      string   `hls:"GROUP-ID,omitempty" json:",omitempty"`
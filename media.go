@@ -13,19 +13,44 @@ type Media struct {
 	MediaHeader
 	File []File `hls:"" json:",omitempty"`
 
+	// Part contains the partial segments of the segment currently being
+	// produced. These trail the last EXTINF tag in a Low-Latency HLS playlist.
+	Part []Part `json:",omitempty"`
+
 	URL string `json:",omitempty"`
+
+	src *source // see DecodeLossless
 }
 
 type MediaHeader struct {
 	M3U           bool          `hls:"EXTM3U" json:",omitempty"`
-	Version       int           `hls:"EXT-X-VERSION" json:",omitempty"`
+	Version       int           `hls:"EXT-X-VERSION,omitempty" json:",omitempty"`
 	Independent   bool          `hls:"EXT-X-INDEPENDENT-SEGMENTS,omitempty" json:",omitempty"`
+	Define        []Define      `hls:"EXT-X-DEFINE,aggr,omitempty" json:",omitempty"`
 	Type          string        `hls:"EXT-X-PLAYLIST-TYPE,noquote,omitempty" json:",omitempty"`
+	IFramesOnly   bool          `hls:"EXT-X-I-FRAMES-ONLY,omitempty" json:",omitempty"`
 	Target        time.Duration `hls:"EXT-X-TARGETDURATION,omitempty" json:",omitempty"`
+	Control       ServerControl `hls:"EXT-X-SERVER-CONTROL,omitempty" json:",omitempty"`
+	PartInf       PartInf       `hls:"EXT-X-PART-INF,omitempty" json:",omitempty"`
 	Start         Start         `hls:"EXT-X-START,omitempty" json:",omitempty"`
 	Sequence      int           `hls:"EXT-X-MEDIA-SEQUENCE,omitempty" json:",omitempty"`
 	Discontinuity int           `hls:"EXT-X-DISCONTINUITY-SEQUENCE,omitempty" json:",omitempty"`
-	End           bool          `hls:"EXT-X-ENDLIST,omitempty" json:",omitempty"`
+	Skip          Skip          `hls:"EXT-X-SKIP,omitempty" json:",omitempty"`
+
+	// Hint and Report are emitted after the last segment, see trailer
+	Hint   []PreloadHint     `hls:"EXT-X-PRELOAD-HINT,aggr,omitempty" json:",omitempty"`
+	Report []RenditionReport `hls:"EXT-X-RENDITION-REPORT,aggr,omitempty" json:",omitempty"`
+	End    bool              `hls:"EXT-X-ENDLIST,omitempty" json:",omitempty"`
+
+	declared int // the EXT-X-VERSION it was decoded with, see setversion
+}
+
+// trailer is the set of header tags that are written after the
+// segments of a media playlist
+var trailer = map[string]bool{
+	"EXT-X-PRELOAD-HINT":     true,
+	"EXT-X-RENDITION-REPORT": true,
+	"EXT-X-ENDLIST":          true,
 }
 
 // Path is Path
@@ -47,11 +72,34 @@ func (m *Media) Decode(r io.Reader) error {
 	return m.DecodeTag(t...)
 }
 
-// DecodeTag decodes the list of tags as a media playlist
+// DecodeStrict is like Decode, except it fails on the first malformed
+// line or value. The error is either a *m3u.SyntaxError or a *ValueError,
+// both of which carry the line number.
+func (m *Media) DecodeStrict(r io.Reader) error {
+	t, master, err := DecodeStrict(r)
+	if err != nil {
+		return err
+	}
+	if master {
+		return ErrType
+	}
+	return m.decode(unmarshalStrict, t...)
+}
+
+// DecodeTag decodes the list of tags as a media playlist. Variables
+// defined with EXT-X-DEFINE are resolved if they only depend on the
+// playlist and its URL, otherwise they must be resolved with Resolve.
+// If the playlist has no EXT-X-DEFINE tags, a variable reference is an
+// error wrapping ErrVar.
 func (m *Media) DecodeTag(t ...m3u.Tag) error {
-	if err := unmarshalTag0(&m.MediaHeader, t...); err != nil {
+	return m.decode(unmarshalTag0, t...)
+}
+
+func (m *Media) decode(unmarshal func(interface{}, ...m3u.Tag) error, t ...m3u.Tag) error {
+	if err := unmarshal(&m.MediaHeader, t...); err != nil {
 		return err
 	}
+	m.declared = m.Version
 	if !m.M3U {
 		return ErrHeader
 	}
@@ -61,7 +109,7 @@ func (m *Media) DecodeTag(t ...m3u.Tag) error {
 		if t[j].Name != "EXTINF" {
 			continue
 		}
-		if err := unmarshalTag0(&file, t[i:j+1]...); err != nil {
+		if err := unmarshal(&file, t[i:j+1]...); err != nil {
 			return err
 		}
 		i = j
@@ -69,25 +117,63 @@ func (m *Media) DecodeTag(t ...m3u.Tag) error {
 		file = file.sticky()
 	}
 
-	if m.Len() == 0 {
+	// partial segments after the last EXTINF belong to a segment
+	// that is still being produced
+	tail := File{}
+	if err := unmarshal(&tail, t[i:]...); err != nil {
+		return err
+	}
+	m.Part = append(m.Part, tail.Part...)
+
+	switch {
+	case len(m.Define) == 0:
+		if err := undefined(t...); err != nil {
+			return err
+		}
+	case selfcontained(m.Define, m.URL):
+		if err := m.Resolve(nil); err != nil {
+			return err
+		}
+	}
+
+	if m.Len() == 0 && len(m.Part) == 0 {
 		return ErrEmpty
 	}
 	return nil
 }
 
+// Encode encodes the media playlist. If m was decoded with DecodeLossless,
+// only the parts of the playlist that changed are rewritten.
+//
+// If m.Version is zero, or still the version the playlist was decoded with,
+// it's raised to the lowest version compatible with the features used by
+// the playlist. If the caller set it any lower, Encode returns ErrVersion.
 func (m Media) Encode(w io.Writer) (err error) {
+	if err := m.setversion(); err != nil {
+		return err
+	}
+	if m.src != nil {
+		if _, err := m.EncodeTag(); err != nil {
+			return err
+		}
+		return m.src.encode(w, m.elements(), m.kinds())
+	}
 	return writeplaylist(m, w)
 }
 
 func (m Media) EncodeTag() (t []m3u.Tag, err error) {
+	if err := m.setversion(); err != nil {
+		return nil, err
+	}
 	if t, err = marshalTag0(m.MediaHeader); err != nil {
 		return t, err
 	}
-	var trailer []m3u.Tag
-	if len(t) > 0 && t[len(t)-1].Name == "EXT-X-ENDLIST" {
-		trailer = append(trailer, t[len(t)-1])
-		t = t[:len(t)-1]
+	n := len(t)
+	for n > 0 && trailer[t[n-1].Name] {
+		n--
 	}
+	trailer := append([]m3u.Tag{}, t[n:]...)
+	t = t[:n]
 	for _, v := range m.File {
 		tmp, err := marshalTag0(v)
 		t = append(t, tmp...)
@@ -95,7 +181,9 @@ func (m Media) EncodeTag() (t []m3u.Tag, err error) {
 			return t, err
 		}
 	}
-	return append(t, trailer...), err
+	t = append(t, marshalPart(m.Part...)...)
+	t = append(t, trailer...)
+	return t, unquotable(t)
 }
 
 // Current returns the most-recent segment in the stream
@@ -106,6 +194,27 @@ func (m *Media) Current() (f File) {
 	return m.File[len(m.File)-1]
 }
 
+// Seq returns the media sequence number of the i-th segment in m.File. This
+// accounts for segments skipped by a delta update.
+func (m *Media) Seq(i int) int {
+	return m.Sequence + m.Skip.Segments + i
+}
+
+// Rotations returns the indices of the segments in m.File where the set
+// of active keys changes. These are the only segments that need EXT-X-KEY
+// tags when the playlist is encoded. The first segment is included
+// only if it's encrypted.
+func (m *Media) Rotations() (index []int) {
+	var key Keys
+	for i, f := range m.File {
+		if !f.Key.Equal(key) {
+			index = append(index, i)
+		}
+		key = f.Key
+	}
+	return index
+}
+
 // Len returns the number of segments visibile to the playlist
 func (m *Media) Len() int {
 	return len(m.File)
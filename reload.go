@@ -0,0 +1,67 @@
+package hls
+
+// CheckReload compares two successive fetches of the same live media
+// playlist and reports the changes the server isn't allowed to make
+// between them. The index of a Finding refers to cur.File, or is -1
+// if the finding applies to the playlist as a whole.
+//
+// Either playlist may be a delta update, but segments replaced by an
+// EXT-X-SKIP tag can't be compared.
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis#section-6.2.1
+func CheckReload(prev, cur Media) []Finding {
+	var fs findings
+	if prev.End && !cur.End {
+		fs.add(Must, "reload-endlist", "6.2.1", "EXT-X-ENDLIST", -1, "EXT-X-ENDLIST was removed")
+	}
+	if prev.Type != cur.Type {
+		fs.add(Must, "reload-playlist-type", "4.4.3.5", "EXT-X-PLAYLIST-TYPE", -1, "playlist type changed from %q to %q", prev.Type, cur.Type)
+	}
+	if cur.Sequence < prev.Sequence {
+		fs.add(Must, "reload-sequence", "6.2.1", "EXT-X-MEDIA-SEQUENCE", -1, "media sequence went backwards from %d to %d", prev.Sequence, cur.Sequence)
+		return fs
+	}
+	if cur.Sequence > prev.Sequence && prev.Type != Live {
+		fs.add(Must, "reload-remove", "4.4.3.5", "EXT-X-MEDIA-SEQUENCE", -1, "%s playlist removed %d segments", prev.Type, cur.Sequence-prev.Sequence)
+	}
+
+	// segments that slid out of the playlist; a discontinuity among
+	// them advances the discontinuity sequence. The count is only known
+	// if prev contains every removed segment.
+	removed := 0
+	known := (prev.Skip.Segments == 0 || cur.Sequence == prev.Sequence) && cur.Sequence <= prev.Seq(len(prev.File))
+	for i, f := range prev.File {
+		if prev.Seq(i) < cur.Sequence && f.Discontinuous {
+			removed++
+		}
+	}
+	switch want := prev.Discontinuity + removed; {
+	case cur.Discontinuity < prev.Discontinuity:
+		fs.add(Must, "reload-discontinuity-sequence", "6.2.2", "EXT-X-DISCONTINUITY-SEQUENCE", -1, "discontinuity sequence went backwards from %d to %d", prev.Discontinuity, cur.Discontinuity)
+	case known && cur.Discontinuity != want:
+		fs.add(Must, "reload-discontinuity-sequence", "6.2.2", "EXT-X-DISCONTINUITY-SEQUENCE", -1, "discontinuity sequence is %d, but %d discontinuities were removed since %d", cur.Discontinuity, removed, prev.Discontinuity)
+	}
+
+	for i, f := range cur.File {
+		j := cur.Seq(i) - prev.Seq(0)
+		if j < 0 || j >= len(prev.File) {
+			continue
+		}
+		p := prev.File[j]
+		if p.Inf.URL != f.Inf.URL {
+			fs.add(Must, "reload-segment-uri", "6.2.1", "EXTINF", i, "segment %d changed uri from %q to %q", cur.Seq(i), p.Inf.URL, f.Inf.URL)
+		}
+		if p.Inf.Duration != f.Inf.Duration {
+			fs.add(Must, "reload-segment-duration", "6.2.1", "EXTINF", i, "segment %d changed duration from %s to %s", cur.Seq(i), p.Inf.Duration, f.Inf.Duration)
+		}
+		if p.Discontinuous != f.Discontinuous {
+			fs.add(Must, "reload-segment-discontinuity", "6.2.1", "EXT-X-DISCONTINUITY", i, "segment %d changed its discontinuity", cur.Seq(i))
+		}
+	}
+	if prev.End {
+		if n, m := prev.Seq(len(prev.File)), cur.Seq(len(cur.File)); m > n {
+			fs.add(Must, "reload-after-endlist", "6.2.1", "EXTINF", len(cur.File)-(m-n), "%d segments were added after EXT-X-ENDLIST", m-n)
+		}
+	}
+	return fs
+}
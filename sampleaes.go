@@ -0,0 +1,56 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/as/hls/sampleaes"
+)
+
+// DecryptTS decrypts the SAMPLE-AES MPEG-TS segment f read from r and
+// calls fn with every elementary stream access unit. The segment's media
+// sequence number, seq, is used when the key has no IV.
+func DecryptTS(r io.Reader, f File, seq int, fetch KeyFunc, fn func(sampleaes.Sample) error) error {
+	key, iv, err := samplekey(f, seq, fetch, "SAMPLE-AES")
+	if err != nil {
+		return err
+	}
+	c, err := sampleaes.NewCipher(key, iv)
+	if err != nil {
+		return err
+	}
+	return sampleaes.DecryptTS(r, c, fn)
+}
+
+// DecryptMP4 decrypts the SAMPLE-AES or SAMPLE-AES-CTR fragmented MP4
+// segment seg in place. The init segment is the content of f.Map.
+func DecryptMP4(init, seg []byte, f File, seq int, fetch KeyFunc) error {
+	key, iv, err := samplekey(f, seq, fetch, "SAMPLE-AES", "SAMPLE-AES-CTR")
+	if err != nil {
+		return err
+	}
+	return sampleaes.DecryptMP4(init, seg, key, iv)
+}
+
+// samplekey returns the key and iv for a segment encrypted with
+// one of the given methods
+func samplekey(f File, seq int, fetch KeyFunc, method ...string) (key, iv []byte, err error) {
+	k, ok := f.Key.Format("identity")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: segment has no identity key", ErrMethod)
+	}
+	supported := false
+	for _, m := range method {
+		supported = supported || k.Method == m
+	}
+	if !supported {
+		return nil, nil, fmt.Errorf("%w: %s", ErrMethod, k.Method)
+	}
+	if key, err = fetch(k); err != nil {
+		return nil, nil, err
+	}
+	if iv, err = k.Vector(seq); err != nil {
+		return nil, nil, err
+	}
+	return key, iv, nil
+}
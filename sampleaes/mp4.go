@@ -0,0 +1,396 @@
+package sampleaes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// DecryptMP4 decrypts the samples of the fragmented MP4 segment seg in place.
+// The protection scheme and default parameters come from the tenc box of
+// the initialization segment init (the EXT-X-MAP), and the per-sample IVs
+// and subsample layout from the senc box of each track fragment.
+//
+// Both cbcs (SAMPLE-AES) and cenc (SAMPLE-AES-CTR) are supported. The
+// fallback iv is used for tracks that carry neither a per-sample nor a
+// constant IV. Tracks that aren't protected are left alone.
+func DecryptMP4(init, seg, key, iv []byte) error {
+	return mp4(init, seg, key, iv, true)
+}
+
+// EncryptMP4 is the inverse of DecryptMP4. The segment must already
+// carry the senc boxes that describe how to encrypt it.
+func EncryptMP4(init, seg, key, iv []byte) error {
+	return mp4(init, seg, key, iv, false)
+}
+
+// track is the protection information for a track in the init segment
+type track struct {
+	scheme     string
+	protected  bool
+	ivsize     int
+	crypt      int
+	skip       int
+	constantIV []byte
+	size       uint32 // default sample size from trex
+}
+
+type box struct {
+	typ  string
+	off  int // offset of the box, relative to the start of the file
+	data []byte
+}
+
+// boxes returns the boxes in b, which starts at offset off in the file
+func boxes(b []byte, off int) (list []box, err error) {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return list, fmt.Errorf("%w: truncated box", ErrFormat)
+		}
+		size, hdr := uint64(binary.BigEndian.Uint32(b)), 8
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return list, fmt.Errorf("%w: truncated box", ErrFormat)
+			}
+			size, hdr = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < uint64(hdr) || size > uint64(len(b)) {
+			return list, fmt.Errorf("%w: bad box size", ErrFormat)
+		}
+		list = append(list, box{typ: string(b[4:8]), off: off, data: b[hdr:size]})
+		b, off = b[size:], off+int(size)
+	}
+	return list, nil
+}
+
+// find returns the first box at the end of the path
+func find(b []byte, path ...string) ([]byte, bool) {
+	list, _ := boxes(b, 0)
+	for _, v := range list {
+		if v.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return v.data, true
+		}
+		return find(v.data, path[1:]...)
+	}
+	return nil, false
+}
+
+func parseInit(init []byte) (map[uint32]*track, error) {
+	moov, ok := find(init, "moov")
+	if !ok {
+		return nil, fmt.Errorf("%w: no moov in init segment", ErrFormat)
+	}
+	list, err := boxes(moov, 0)
+	if err != nil {
+		return nil, err
+	}
+	tracks := map[uint32]*track{}
+	for _, trak := range list {
+		if trak.typ != "trak" {
+			continue
+		}
+		tkhd, ok := find(trak.data, "tkhd")
+		if !ok || len(tkhd) < 24 {
+			return nil, fmt.Errorf("%w: bad tkhd", ErrFormat)
+		}
+		id := binary.BigEndian.Uint32(tkhd[12:])
+		if tkhd[0] == 1 {
+			id = binary.BigEndian.Uint32(tkhd[20:])
+		}
+		t := &track{}
+		tracks[id] = t
+		stsd, ok := find(trak.data, "mdia", "minf", "stbl", "stsd")
+		if !ok || len(stsd) < 8 {
+			continue
+		}
+		entries, err := boxes(stsd[8:], 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			var skip int
+			switch e.typ {
+			case "encv":
+				skip = 78
+			case "enca":
+				skip = 28
+			default:
+				continue
+			}
+			if len(e.data) < skip {
+				return nil, fmt.Errorf("%w: bad sample entry", ErrFormat)
+			}
+			if err := t.sinf(e.data[skip:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if trex, ok := find(moov, "mvex"); ok {
+		list, _ := boxes(trex, 0)
+		for _, v := range list {
+			if v.typ == "trex" && len(v.data) >= 24 {
+				if t := tracks[binary.BigEndian.Uint32(v.data[4:])]; t != nil {
+					t.size = binary.BigEndian.Uint32(v.data[16:])
+				}
+			}
+		}
+	}
+	return tracks, nil
+}
+
+// sinf parses the protection scheme info in the children of a sample entry
+func (t *track) sinf(children []byte) error {
+	schm, ok := find(children, "sinf", "schm")
+	if !ok || len(schm) < 8 {
+		return fmt.Errorf("%w: no schm", ErrFormat)
+	}
+	t.scheme = string(schm[4:8])
+	tenc, ok := find(children, "sinf", "schi", "tenc")
+	if !ok || len(tenc) < 24 {
+		return fmt.Errorf("%w: no tenc", ErrFormat)
+	}
+	if tenc[0] > 0 {
+		t.crypt, t.skip = int(tenc[5]>>4), int(tenc[5]&0x0f)
+	}
+	t.protected = tenc[6] != 0
+	t.ivsize = int(tenc[7])
+	if t.protected && t.ivsize == 0 {
+		if len(tenc) < 25 || len(tenc) < 25+int(tenc[24]) {
+			return fmt.Errorf("%w: bad constant iv", ErrFormat)
+		}
+		t.constantIV = tenc[25 : 25+int(tenc[24])]
+	}
+	return nil
+}
+
+func mp4(init, seg, key, fallback []byte, decrypt bool) error {
+	if len(key) != 16 {
+		return ErrKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	tracks, err := parseInit(init)
+	if err != nil {
+		return err
+	}
+	top, err := boxes(seg, 0)
+	if err != nil {
+		return err
+	}
+	for _, moof := range top {
+		if moof.typ != "moof" {
+			continue
+		}
+		list, err := boxes(moof.data, moof.off+8)
+		if err != nil {
+			return err
+		}
+		for _, traf := range list {
+			if traf.typ != "traf" {
+				continue
+			}
+			if err := fragment(seg, moof.off, traf.data, tracks, block, fallback, decrypt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sample is the position and encryption parameters of a sample in the segment
+type sample struct {
+	off, size  int
+	iv         []byte
+	subsamples [][2]int // clear, protected
+}
+
+// fragment processes the samples described by the traf box
+func fragment(seg []byte, moof int, traf []byte, tracks map[uint32]*track, block cipher.Block, fallback []byte, decrypt bool) error {
+	list, err := boxes(traf, 0)
+	if err != nil {
+		return err
+	}
+	var (
+		t       *track
+		base    = moof
+		defsize uint32
+		samples []sample
+	)
+	for _, b := range list {
+		d := b.data
+		switch b.typ {
+		case "tfhd":
+			if len(d) < 8 {
+				return fmt.Errorf("%w: bad tfhd", ErrFormat)
+			}
+			flags := binary.BigEndian.Uint32(d) & 0xffffff
+			if t = tracks[binary.BigEndian.Uint32(d[4:])]; t == nil {
+				return nil
+			}
+			defsize = t.size
+			d = d[8:]
+			if flags&0x01 != 0 && len(d) >= 8 {
+				base, d = int(binary.BigEndian.Uint64(d)), d[8:]
+			}
+			for _, f := range []uint32{0x02, 0x08} {
+				if flags&f != 0 && len(d) >= 4 {
+					d = d[4:]
+				}
+			}
+			if flags&0x10 != 0 && len(d) >= 4 {
+				defsize = binary.BigEndian.Uint32(d)
+			}
+		case "trun":
+			if t == nil || len(d) < 8 {
+				return fmt.Errorf("%w: trun before tfhd", ErrFormat)
+			}
+			flags := binary.BigEndian.Uint32(d) & 0xffffff
+			n := int(binary.BigEndian.Uint32(d[4:]))
+			d = d[8:]
+			pos := base
+			if len(samples) > 0 {
+				last := samples[len(samples)-1]
+				pos = last.off + last.size
+			}
+			if flags&0x01 != 0 && len(d) >= 4 {
+				pos, d = base+int(int32(binary.BigEndian.Uint32(d))), d[4:]
+			}
+			if flags&0x04 != 0 && len(d) >= 4 {
+				d = d[4:]
+			}
+			for i := 0; i < n; i++ {
+				size := defsize
+				for _, f := range []uint32{0x100, 0x200, 0x400, 0x800} {
+					if flags&f == 0 {
+						continue
+					}
+					if len(d) < 4 {
+						return fmt.Errorf("%w: truncated trun", ErrFormat)
+					}
+					if f == 0x200 {
+						size = binary.BigEndian.Uint32(d)
+					}
+					d = d[4:]
+				}
+				samples = append(samples, sample{off: pos, size: int(size)})
+				pos += int(size)
+			}
+		}
+	}
+	if t == nil || !t.protected {
+		return nil
+	}
+	senc, ok := find(traf, "senc")
+	if !ok {
+		return fmt.Errorf("%w: no senc", ErrFormat)
+	}
+	if err := t.senc(senc, samples, fallback); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		if s.off < 0 || s.off+s.size > len(seg) {
+			return fmt.Errorf("%w: sample out of range", ErrFormat)
+		}
+		if err := t.process(block, seg[s.off:s.off+s.size], s, decrypt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// senc fills in the IV and subsample layout of each sample
+func (t *track) senc(d []byte, samples []sample, fallback []byte) error {
+	if len(d) < 8 {
+		return fmt.Errorf("%w: bad senc", ErrFormat)
+	}
+	sub := binary.BigEndian.Uint32(d)&0x02 != 0
+	if n := int(binary.BigEndian.Uint32(d[4:])); n != len(samples) {
+		return fmt.Errorf("%w: senc has %d samples, trun has %d", ErrFormat, n, len(samples))
+	}
+	d = d[8:]
+	for i := range samples {
+		s := &samples[i]
+		switch {
+		case t.ivsize > 0:
+			if len(d) < t.ivsize {
+				return fmt.Errorf("%w: truncated senc", ErrFormat)
+			}
+			s.iv, d = d[:t.ivsize], d[t.ivsize:]
+		case t.constantIV != nil:
+			s.iv = t.constantIV
+		default:
+			s.iv = fallback
+		}
+		if !sub {
+			continue
+		}
+		if len(d) < 2 {
+			return fmt.Errorf("%w: truncated senc", ErrFormat)
+		}
+		n := int(binary.BigEndian.Uint16(d))
+		d = d[2:]
+		if len(d) < 6*n {
+			return fmt.Errorf("%w: truncated senc", ErrFormat)
+		}
+		for j := 0; j < n; j++ {
+			s.subsamples = append(s.subsamples, [2]int{
+				int(binary.BigEndian.Uint16(d)),
+				int(binary.BigEndian.Uint32(d[2:])),
+			})
+			d = d[6:]
+		}
+	}
+	return nil
+}
+
+// process encrypts or decrypts the sample data in place
+func (t *track) process(block cipher.Block, data []byte, s sample, decrypt bool) error {
+	iv := make([]byte, aes.BlockSize)
+	if len(s.iv) != 8 && len(s.iv) != 16 {
+		return ErrIV
+	}
+	copy(iv, s.iv)
+
+	sub := s.subsamples
+	if sub == nil {
+		sub = [][2]int{{0, len(data)}}
+	}
+	var ctr cipher.Stream
+	if t.scheme == "cenc" {
+		ctr = cipher.NewCTR(block, iv)
+	}
+	for _, v := range sub {
+		clear, protected := v[0], v[1]
+		if clear+protected > len(data) {
+			return fmt.Errorf("%w: subsample out of range", ErrFormat)
+		}
+		p := data[clear : clear+protected]
+		data = data[clear+protected:]
+		switch t.scheme {
+		case "cenc":
+			// the counter runs across all of the protected ranges in the sample
+			ctr.XORKeyStream(p, p)
+		case "cbcs":
+			// the cipher block chain restarts with every subsample
+			var mode cipher.BlockMode
+			if decrypt {
+				mode = cipher.NewCBCDecrypter(block, iv)
+			} else {
+				mode = cipher.NewCBCEncrypter(block, iv)
+			}
+			pattern(mode, p, t.crypt, t.skip)
+		default:
+			return fmt.Errorf("%w: %s", ErrScheme, t.scheme)
+		}
+	}
+	return nil
+}
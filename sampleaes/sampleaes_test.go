@@ -0,0 +1,278 @@
+package sampleaes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var (
+	testKey = []byte("0123456789abcdef")
+	testIV  = []byte("fedcba9876543210")
+)
+
+// testNAL returns an IDR slice of size n that needs emulation prevention
+func testNAL(n int) []byte {
+	raw := []byte{0x65}
+	for i := 1; i < n-1; i++ {
+		raw = append(raw, byte(i%7))
+	}
+	return escape(append(raw, 0x80))
+}
+
+func testADTS(n int) []byte {
+	f := make([]byte, n)
+	f[0], f[1] = 0xff, 0xf1
+	f[3] = byte(n >> 11 & 0x03)
+	f[4] = byte(n >> 3)
+	f[5] = byte(n&7<<5) | 0x1f
+	f[6] = 0xfc
+	for i := 7; i < n; i++ {
+		f[i] = byte(i)
+	}
+	return f
+}
+
+func TestNAL(t *testing.T) {
+	c, err := NewCipher(testKey, testIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{10, 48, 49, 64, 65, 300, 1000} {
+		nal := testNAL(n)
+		enc := c.EncryptNAL(nal)
+		if bytes.Contains(enc, []byte{0, 0, 1}) {
+			t.Fatalf("size %d: encrypted nal contains a start code", n)
+		}
+		if changed := !bytes.Equal(enc, nal); changed != (len(unescape(nal)) > 48) {
+			t.Fatalf("size %d: encrypted=%v", n, changed)
+		}
+		if n > 48 && !bytes.Equal(unescape(enc)[:32], unescape(nal)[:32]) {
+			t.Fatalf("size %d: clear leader was encrypted", n)
+		}
+		if dec := c.DecryptNAL(enc); !bytes.Equal(dec, nal) {
+			t.Fatalf("size %d: round trip failed", n)
+		}
+	}
+
+	// non-slice NAL units are never encrypted
+	sps := append([]byte{0x67}, testNAL(100)[1:]...)
+	if !bytes.Equal(c.EncryptNAL(sps), sps) {
+		t.Fatal("sps was encrypted")
+	}
+}
+
+func TestADTS(t *testing.T) {
+	c, _ := NewCipher(testKey, testIV)
+	for _, n := range []int{7, 23, 24, 40, 100} {
+		frame := testADTS(n)
+		enc := append([]byte{}, frame...)
+		if err := c.EncryptADTS(enc); err != nil {
+			t.Fatal(err)
+		}
+		if n >= 7+16 && !bytes.Equal(enc[:7+16], frame[:7+16]) {
+			t.Fatalf("size %d: header or leader was encrypted", n)
+		}
+		if changed := !bytes.Equal(enc, frame); changed != (n-7-16 >= 16) {
+			t.Fatalf("size %d: encrypted=%v", n, changed)
+		}
+		if err := c.DecryptADTS(enc); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(enc, frame) {
+			t.Fatalf("size %d: round trip failed", n)
+		}
+	}
+	if err := c.DecryptADTS([]byte("not an adts frame")); err == nil {
+		t.Fatal("expected error for missing sync word")
+	}
+}
+
+// packetize splits the payload into transport stream packets
+func packetize(pid uint16, payload []byte) (ts []byte) {
+	for first := true; len(payload) > 0; first = false {
+		p := []byte{0x47, byte(pid >> 8 & 0x1f), byte(pid), 0x10}
+		if first {
+			p[1] |= 0x40
+		}
+		n := min(len(payload), packetSize-4)
+		if n < packetSize-4 {
+			// stuff the remainder with an adaptation field
+			p[3] |= 0x20
+			af := packetSize - 4 - n - 1
+			p = append(p, byte(af))
+			if af > 0 {
+				p = append(p, 0x00)
+				p = append(p, bytes.Repeat([]byte{0xff}, af-1)...)
+			}
+		}
+		ts = append(ts, append(p, payload[:n]...)...)
+		payload = payload[n:]
+	}
+	return ts
+}
+
+func mksection(id byte, body []byte) []byte {
+	n := len(body) + 5 + 4
+	s := []byte{0, id, 0xb0 | byte(n>>8), byte(n), 0, 1, 0xc1, 0, 0}
+	return append(append(s, body...), 0, 0, 0, 0)
+}
+
+func pes(id byte, pts int64, es []byte) []byte {
+	p := []byte{0, 0, 1, id, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | pts>>29&0x0e), byte(pts >> 22), byte(pts>>14 | 1), byte(pts >> 7), byte(pts<<1 | 1),
+	}
+	return append(p, es...)
+}
+
+func TestTS(t *testing.T) {
+	c, _ := NewCipher(testKey, testIV)
+	nals := [][]byte{{0x09, 0xf0}, testNAL(500), testNAL(40), testNAL(1000)}
+	var clear, enc []byte
+	for _, nal := range nals {
+		clear = append(append(clear, 0, 0, 0, 1), nal...)
+		enc = append(append(enc, 0, 0, 0, 1), c.EncryptNAL(nal)...)
+	}
+	aac := append(testADTS(200), testADTS(300)...)
+	aacenc := append([]byte{}, aac...)
+	for es := aacenc; len(es) > 0; es = es[adtsLen(es):] {
+		c.EncryptADTS(es[:adtsLen(es)])
+	}
+
+	var ts []byte
+	ts = append(ts, packetize(0, mksection(0x00, []byte{0, 1, 0xe0, 0x20}))...)
+	ts = append(ts, packetize(0x20, mksection(0x02, []byte{
+		0xe1, 0x00, 0xf0, 0x00,
+		TypeH264Sample, 0xe1, 0x00, 0xf0, 0x00,
+		TypeAACSample, 0xe1, 0x01, 0xf0, 0x00,
+	}))...)
+	ts = append(ts, packetize(0x100, pes(0xe0, 9000, enc))...)
+	ts = append(ts, packetize(0x101, pes(0xc0, 9001, aacenc))...)
+	ts = append(ts, packetize(0x100, pes(0xe0, 12000, enc))...)
+
+	var got []Sample
+	err := DecryptTS(bytes.NewReader(ts), c, func(s Sample) error {
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a PES packet is complete when the next one on its PID starts, or at EOF
+	want := []Sample{
+		{PID: 0x100, Type: TypeH264Sample, PTS: 9000, Data: clear},
+		{PID: 0x100, Type: TypeH264Sample, PTS: 12000, Data: clear},
+		{PID: 0x101, Type: TypeAACSample, PTS: 9001, Data: aac},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("have %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		h, w := got[i], want[i]
+		if h.PID != w.PID || h.Type != w.Type || h.PTS != w.PTS || !bytes.Equal(h.Data, w.Data) {
+			t.Fatalf("sample %d: have %d/%x/%d, want %d/%x/%d", i, h.PID, h.Type, h.PTS, w.PID, w.Type, w.PTS)
+		}
+	}
+}
+
+func mkbox(typ string, data ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func u32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, v := range v {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+// testMP4 returns an init segment and a media segment with one
+// protected track containing the samples
+func testMP4(scheme string, ivsize int, samples ...[]byte) (init, seg []byte) {
+	tkhd := append(u32(0, 0, 0, 1), make([]byte, 68)...)
+	tenc := []byte{1, 0, 0, 0, 0, 0x19, 1, byte(ivsize)}
+	tenc = append(tenc, make([]byte, 16)...)
+	if ivsize == 0 {
+		tenc = append(append(tenc, 16), testIV...)
+	}
+	sinf := mkbox("sinf",
+		mkbox("frma", []byte("avc1")),
+		mkbox("schm", u32(0), []byte(scheme), u32(0x10000)),
+		mkbox("schi", mkbox("tenc", tenc)),
+	)
+	encv := mkbox("encv", make([]byte, 78), sinf)
+	stsd := mkbox("stsd", u32(0, 1), encv)
+	trak := mkbox("trak", mkbox("tkhd", tkhd), mkbox("mdia", mkbox("minf", mkbox("stbl", stsd))))
+	init = mkbox("moov", trak, mkbox("mvex", mkbox("trex", u32(0, 1, 1, 0, 0, 0))))
+
+	trun := u32(0x201, uint32(len(samples)), 0)
+	senc := u32(0x2, uint32(len(samples)))
+	var mdat []byte
+	for i, s := range samples {
+		trun = append(trun, u32(uint32(len(s)))...)
+		if ivsize > 0 {
+			iv := append([]byte{}, testIV[:ivsize]...)
+			iv[0] = byte(i)
+			senc = append(senc, iv...)
+		}
+		// one subsample with a 5 byte clear header
+		senc = append(senc, 0, 1, 0, 5)
+		senc = append(senc, u32(uint32(len(s)-5))...)
+		mdat = append(mdat, s...)
+	}
+	traf := mkbox("traf", mkbox("tfhd", u32(0x020000, 1)), mkbox("trun", trun), mkbox("senc", senc))
+	moof := mkbox("moof", mkbox("mfhd", u32(0, 1)), traf)
+
+	// patch the trun data offset, which is relative to the moof
+	off := bytes.Index(moof, []byte("trun")) + 12
+	binary.BigEndian.PutUint32(moof[off:], uint32(len(moof)+8))
+	return init, append(moof, mkbox("mdat", mdat)...)
+}
+
+func TestMP4(t *testing.T) {
+	samples := [][]byte{
+		bytes.Repeat([]byte{1}, 300),
+		bytes.Repeat([]byte{2}, 20),
+		bytes.Repeat([]byte{3}, 1000),
+	}
+	for _, tc := range []struct {
+		scheme string
+		ivsize int
+	}{
+		{"cbcs", 0},
+		{"cbcs", 16},
+		{"cenc", 8},
+		{"cenc", 16},
+	} {
+		init, seg := testMP4(tc.scheme, tc.ivsize, samples...)
+		clear := append([]byte{}, seg...)
+		if err := EncryptMP4(init, seg, testKey, testIV); err != nil {
+			t.Fatalf("%s/%d: encrypt: %v", tc.scheme, tc.ivsize, err)
+		}
+		if bytes.Equal(seg, clear) {
+			t.Fatalf("%s/%d: segment was not encrypted", tc.scheme, tc.ivsize)
+		}
+		mdat := bytes.Index(seg, []byte("mdat")) + 4
+		if !bytes.Equal(seg[:mdat+5], clear[:mdat+5]) {
+			t.Fatalf("%s/%d: clear data was encrypted", tc.scheme, tc.ivsize)
+		}
+		if err := DecryptMP4(init, seg, testKey, testIV); err != nil {
+			t.Fatalf("%s/%d: decrypt: %v", tc.scheme, tc.ivsize, err)
+		}
+		if !bytes.Equal(seg, clear) {
+			t.Fatalf("%s/%d: round trip failed", tc.scheme, tc.ivsize)
+		}
+	}
+
+	init, seg := testMP4("cbc1", 16, samples...)
+	if err := DecryptMP4(init, seg, testKey, testIV); err == nil {
+		t.Fatal("expected error for unsupported scheme")
+	}
+}
@@ -0,0 +1,246 @@
+package sampleaes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream types in the program map table. Apple assigns private stream
+// types to encrypted elementary streams.
+const (
+	TypeAAC        = 0x0f
+	TypeH264       = 0x1b
+	TypeAACSample  = 0xcf // SAMPLE-AES ADTS AAC
+	TypeH264Sample = 0xdb // SAMPLE-AES H.264
+)
+
+const packetSize = 188
+
+// Sample is the elementary stream data carried by one PES packet
+type Sample struct {
+	PID  uint16
+	Type byte  // stream type from the program map table
+	PTS  int64 // -1 if absent
+	Data []byte
+}
+
+// DecryptTS demultiplexes the MPEG-TS segment read from r and calls fn
+// with the decrypted payload of every PES packet that belongs to an H.264
+// or AAC elementary stream. Streams that aren't encrypted are passed through.
+//
+// The decrypted samples are generally not the same size as the encrypted
+// ones, so the segment is not remultiplexed.
+func DecryptTS(r io.Reader, c *Cipher, fn func(Sample) error) error {
+	d := demux{
+		c:     c,
+		fn:    fn,
+		pmt:   map[uint16]bool{},
+		types: map[uint16]byte{},
+		pes:   map[uint16]*bytes.Buffer{},
+	}
+	br := bufio.NewReader(r)
+	pkt := make([]byte, packetSize)
+	for {
+		if _, err := io.ReadFull(br, pkt); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if err := d.packet(pkt); err != nil {
+			return err
+		}
+	}
+	return d.flushAll()
+}
+
+type demux struct {
+	c     *Cipher
+	fn    func(Sample) error
+	pmt   map[uint16]bool
+	types map[uint16]byte
+	pes   map[uint16]*bytes.Buffer
+	order []uint16
+}
+
+func (d *demux) packet(p []byte) error {
+	if p[0] != 0x47 {
+		return fmt.Errorf("%w: lost sync", ErrFormat)
+	}
+	pusi := p[1]&0x40 != 0
+	pid := uint16(p[1]&0x1f)<<8 | uint16(p[2])
+	afc := p[3] >> 4 & 3
+	payload := p[4:]
+	if afc&2 != 0 {
+		if int(payload[0])+1 > len(payload) {
+			return fmt.Errorf("%w: adaptation field too long", ErrFormat)
+		}
+		payload = payload[1+int(payload[0]):]
+	}
+	if afc&1 == 0 {
+		return nil
+	}
+	switch {
+	case pid == 0:
+		return d.pat(psi(payload, pusi))
+	case d.pmt[pid]:
+		return d.pmtable(psi(payload, pusi))
+	}
+	if _, ok := d.types[pid]; !ok {
+		return nil
+	}
+	if pusi {
+		if err := d.flush(pid); err != nil {
+			return err
+		}
+		d.pes[pid] = new(bytes.Buffer)
+	}
+	if b := d.pes[pid]; b != nil {
+		b.Write(payload)
+	}
+	return nil
+}
+
+// psi returns the section in the payload, skipping the pointer field
+func psi(payload []byte, pusi bool) []byte {
+	if !pusi || len(payload) == 0 {
+		return nil
+	}
+	ptr := int(payload[0]) + 1
+	if ptr > len(payload) {
+		return nil
+	}
+	return payload[ptr:]
+}
+
+// section returns the body of the table section, without its header and CRC
+func section(t []byte, id byte, hdr int) []byte {
+	if len(t) < hdr || t[0] != id {
+		return nil
+	}
+	n := int(t[1]&0x0f)<<8 | int(t[2])
+	if 3+n > len(t) || n < hdr-3+4 {
+		return nil
+	}
+	return t[hdr : 3+n-4]
+}
+
+func (d *demux) pat(t []byte) error {
+	for s := section(t, 0x00, 8); len(s) >= 4; s = s[4:] {
+		if program := int(s[0])<<8 | int(s[1]); program != 0 {
+			d.pmt[uint16(s[2]&0x1f)<<8|uint16(s[3])] = true
+		}
+	}
+	return nil
+}
+
+func (d *demux) pmtable(t []byte) error {
+	if len(t) < 12 {
+		return nil
+	}
+	s := section(t, 0x02, 12)
+	if s == nil {
+		return nil
+	}
+	info := int(t[10]&0x0f)<<8 | int(t[11])
+	if info > len(s) {
+		return fmt.Errorf("%w: program info too long", ErrFormat)
+	}
+	for s = s[info:]; len(s) >= 5; {
+		typ, pid := s[0], uint16(s[1]&0x1f)<<8|uint16(s[2])
+		n := int(s[3]&0x0f)<<8 | int(s[4])
+		switch typ {
+		case TypeAAC, TypeH264, TypeAACSample, TypeH264Sample:
+			if _, ok := d.types[pid]; !ok {
+				d.order = append(d.order, pid)
+			}
+			d.types[pid] = typ
+		}
+		if 5+n > len(s) {
+			break
+		}
+		s = s[5+n:]
+	}
+	return nil
+}
+
+func (d *demux) flushAll() error {
+	for _, pid := range d.order {
+		if err := d.flush(pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *demux) flush(pid uint16) error {
+	b := d.pes[pid]
+	if b == nil || b.Len() == 0 {
+		return nil
+	}
+	d.pes[pid] = nil
+	p := b.Bytes()
+	if len(p) < 9 || p[0] != 0 || p[1] != 0 || p[2] != 1 {
+		return fmt.Errorf("%w: bad pes start code", ErrFormat)
+	}
+	hdr := 9 + int(p[8])
+	if hdr > len(p) {
+		return fmt.Errorf("%w: pes header too long", ErrFormat)
+	}
+	s := Sample{PID: pid, Type: d.types[pid], PTS: -1, Data: p[hdr:]}
+	if p[7]&0x80 != 0 && len(p) >= 14 {
+		s.PTS = int64(p[9]>>1&0x07)<<30 | int64(p[10])<<22 | int64(p[11]>>1)<<15 | int64(p[12])<<7 | int64(p[13]>>1)
+	}
+	var err error
+	switch s.Type {
+	case TypeH264Sample:
+		s.Data = d.c.annexb(s.Data)
+	case TypeAACSample:
+		err = d.c.frames(s.Data)
+	}
+	if err != nil {
+		return err
+	}
+	return d.fn(s)
+}
+
+// annexb decrypts every NAL unit in the H.264 byte stream
+func (c *Cipher) annexb(es []byte) []byte {
+	out := make([]byte, 0, len(es))
+	for len(es) > 0 {
+		i := bytes.Index(es, []byte{0, 0, 1})
+		if i < 0 {
+			return append(out, es...)
+		}
+		out = append(out, es[:i+3]...)
+		es = es[i+3:]
+		end := bytes.Index(es, []byte{0, 0, 1})
+		if end < 0 {
+			end = len(es)
+		}
+		for end > 0 && es[end-1] == 0 {
+			end--
+		}
+		out = append(out, c.DecryptNAL(es[:end])...)
+		es = es[end:]
+	}
+	return out
+}
+
+// frames decrypts every ADTS frame in the AAC stream in place
+func (c *Cipher) frames(es []byte) error {
+	for len(es) > 0 {
+		n := adtsLen(es)
+		if n < 7 || n > len(es) {
+			return fmt.Errorf("%w: bad adts frame length", ErrFormat)
+		}
+		if err := c.DecryptADTS(es[:n]); err != nil {
+			return err
+		}
+		es = es[n:]
+	}
+	return nil
+}
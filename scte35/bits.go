@@ -0,0 +1,107 @@
+package scte35
+
+// reader reads big-endian bit fields. Reading past the end sets
+// err and returns zeroes.
+type reader struct {
+	b   []byte
+	off int // in bits
+	err error
+}
+
+func (r *reader) read(n int) (v uint64) {
+	if r.off+n > len(r.b)*8 {
+		r.err = ErrFormat
+		r.off = len(r.b) * 8
+		return 0
+	}
+	for ; n > 0; n-- {
+		bit := r.b[r.off/8] >> (7 - r.off%8) & 1
+		v = v<<1 | uint64(bit)
+		r.off++
+	}
+	return v
+}
+
+func (r *reader) flag() bool {
+	return r.read(1) == 1
+}
+
+func (r *reader) skip(n int) {
+	r.read(n)
+}
+
+// bytes reads n whole bytes. The reader must be byte aligned.
+func (r *reader) bytes(n int) []byte {
+	if r.off%8 != 0 || r.off/8+n > len(r.b) {
+		r.err = ErrFormat
+		r.off = len(r.b) * 8
+		return nil
+	}
+	p := r.b[r.off/8 : r.off/8+n]
+	r.off += n * 8
+	return append([]byte{}, p...)
+}
+
+// left returns the number of unread bytes
+func (r *reader) left() int {
+	return len(r.b) - r.off/8
+}
+
+// writer writes big-endian bit fields
+type writer struct {
+	b   []byte
+	off int // in bits
+}
+
+func (w *writer) write(v uint64, n int) {
+	for n--; n >= 0; n-- {
+		if w.off%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>n&1) << (7 - w.off%8)
+		w.off++
+	}
+}
+
+func (w *writer) flag(b bool) {
+	if b {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+}
+
+// reserved writes n reserved bits, which are all ones
+func (w *writer) reserved(n int) {
+	w.write(1<<n-1, n)
+}
+
+// bytes writes p. The writer must be byte aligned.
+func (w *writer) bytes(p []byte) {
+	w.b = append(w.b, p...)
+	w.off += len(p) * 8
+}
+
+var crctab = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc32 is the MPEG-2 CRC used by splice_info_section
+func crc32(p []byte) uint32 {
+	c := uint32(0xffffffff)
+	for _, b := range p {
+		c = c<<8 ^ crctab[byte(c>>24)^b]
+	}
+	return c
+}
@@ -0,0 +1,584 @@
+// Package scte35 implements a codec for the SCTE-35 splice_info_section,
+// the binary cue message carried in HLS by EXT-X-DATERANGE (as hex),
+// EXT-X-CUE-OUT, EXT-X-SCTE35 and EXT-OATCLS-SCTE35 (as base64).
+//
+// The splice_null, splice_insert and time_signal commands are decoded, as
+// are avail and segmentation descriptors. Other commands and descriptors
+// are kept as raw bytes, so every section can be re-encoded.
+//
+// https://www.scte.org/standards/library/catalog/scte-35-digital-program-insertion-cueing-message/
+package scte35
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrFormat    = errors.New("scte35: malformed splice_info_section")
+	ErrTable     = errors.New("scte35: not a splice_info_section")
+	ErrCRC       = errors.New("scte35: crc mismatch")
+	ErrEncrypted = errors.New("scte35: encrypted sections are not supported")
+)
+
+// Splice command types
+const (
+	Null                 = 0x00
+	Insert               = 0x05
+	TimeSignal           = 0x06
+	BandwidthReservation = 0x07
+	Private              = 0xff
+)
+
+// Descriptor tags
+const (
+	AvailTag        = 0x00
+	DTMFTag         = 0x01
+	SegmentationTag = 0x02
+	TimeTag         = 0x03
+	AudioTag        = 0x04
+)
+
+// CUEI is the identifier of the descriptors defined by SCTE-35
+const CUEI = 0x43554549
+
+// Clock is the rate of the PTS clock
+const Clock = 90000
+
+// Splice is a splice_info_section. Exactly one of Insert and Time is set
+// for the splice_insert and time_signal commands. Other commands are
+// stored in Command.
+type Splice struct {
+	SAPType   uint8
+	Protocol  uint8
+	PTSAdjust uint64
+	CWIndex   uint8
+	Tier      uint16
+	Type      uint8 // splice_command_type
+
+	Insert     *SpliceInsert
+	Time       *SpliceTime // time_signal
+	Command    []byte      // other commands, raw
+	Descriptor []Descriptor
+}
+
+// SpliceTime is a splice_time. The PTS is only valid if Specified is set.
+type SpliceTime struct {
+	Specified bool
+	PTS       uint64
+}
+
+// SpliceInsert is the splice_insert command. Time is used for program
+// splices that aren't immediate, and Component for component splices.
+type SpliceInsert struct {
+	EventID   uint32
+	Cancel    bool
+	Out       bool // out_of_network_indicator
+	Program   bool
+	Immediate bool
+	Time      SpliceTime
+	Component []Component
+
+	// Duration is set if the break has a duration
+	Duration   *BreakDuration
+	ProgramID  uint16
+	AvailNum   uint8
+	AvailCount uint8 // avails_expected
+}
+
+// BreakDuration is a break_duration, in PTS ticks
+type BreakDuration struct {
+	AutoReturn bool
+	Duration   uint64
+}
+
+// Component is an elementary stream of a component splice. For
+// splice_insert Time is the splice time, and for segmentation descriptors
+// Time.PTS is the pts_offset.
+type Component struct {
+	Tag  uint8
+	Time SpliceTime
+}
+
+// Descriptor is a splice_descriptor. Segmentation is set for segmentation
+// descriptors, otherwise the bytes after the identifier are in Data.
+type Descriptor struct {
+	Tag          uint8
+	ID           uint32
+	Segmentation *Segmentation
+	Data         []byte
+}
+
+// Segmentation is the segmentation_descriptor
+type Segmentation struct {
+	EventID   uint32
+	Cancel    bool
+	Program   bool
+	Component []Component
+
+	// Restricted is true if delivery_not_restricted_flag is clear. The
+	// other restriction flags are only meaningful if it's set.
+	Restricted         bool
+	WebDelivery        bool
+	NoRegionalBlackout bool
+	Archive            bool
+	DeviceRestrictions uint8
+
+	Duration *uint64 // segmentation_duration in PTS ticks, nil if absent
+	UPID     UPID
+	TypeID   uint8
+	Num      uint8
+	Expected uint8
+
+	// Sub is set if the descriptor has sub_segment_num and
+	// sub_segments_expected
+	Sub         bool
+	SubNum      uint8
+	SubExpected uint8
+}
+
+// Segmentation type ids for the most common events
+const (
+	ProgramStart                    = 0x10
+	ProgramEnd                      = 0x11
+	ChapterStart                    = 0x20
+	ChapterEnd                      = 0x21
+	BreakStart                      = 0x22
+	BreakEnd                        = 0x23
+	ProviderAdStart                 = 0x30
+	ProviderAdEnd                   = 0x31
+	DistributorAdStart              = 0x32
+	DistributorAdEnd                = 0x33
+	ProviderPlacementOpportunity    = 0x34
+	ProviderPlacementOpportunityEnd = 0x35
+)
+
+// UPID is a segmentation_upid. For the MID type (0x0d), Value contains
+// the nested UPIDs, see Sub.
+type UPID struct {
+	Type  uint8
+	Value []byte
+}
+
+// UPID types with textual values
+const (
+	UPIDUser = 0x01
+	UPIDISCI = 0x02
+	UPIDAdID = 0x03
+	UPIDISAN = 0x06
+	UPIDTID  = 0x07
+	UPIDTI   = 0x08
+	UPIDADI  = 0x09
+	UPIDEIDR = 0x0a
+	UPIDMPU  = 0x0c
+	UPIDMID  = 0x0d
+	UPIDADS  = 0x0e
+	UPIDURI  = 0x0f
+	UPIDUUID = 0x10
+)
+
+// String returns the value as text for the types defined as text, and
+// as hex for the others
+func (u UPID) String() string {
+	switch u.Type {
+	case UPIDUser, UPIDISCI, UPIDAdID, UPIDTID, UPIDADI, UPIDADS, UPIDURI:
+		return string(u.Value)
+	}
+	return "0x" + strings.ToUpper(hex.EncodeToString(u.Value))
+}
+
+// Sub returns the UPIDs contained in a MID UPID
+func (u UPID) Sub() (list []UPID, err error) {
+	if u.Type != UPIDMID {
+		return nil, nil
+	}
+	r := &reader{b: u.Value}
+	for r.left() > 0 && r.err == nil {
+		t, n := uint8(r.read(8)), int(r.read(8))
+		list = append(list, UPID{Type: t, Value: r.bytes(n)})
+	}
+	return list, r.err
+}
+
+// Parse decodes a splice_info_section in hex (with a 0x prefix),
+// base64 or binary form.
+func Parse(s string) (*Splice, error) {
+	switch {
+	case len(s) > 0 && s[0] == 0xfc:
+		return Decode([]byte(s))
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, ErrFormat
+		}
+		return Decode(b)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrFormat
+	}
+	return Decode(b)
+}
+
+// Decode decodes the binary splice_info_section in b and checks its CRC
+func Decode(b []byte) (*Splice, error) {
+	r := &reader{b: b}
+	if r.read(8) != 0xfc {
+		return nil, ErrTable
+	}
+	r.skip(2) // section_syntax_indicator, private_indicator
+	s := &Splice{}
+	s.SAPType = uint8(r.read(2))
+	n := int(r.read(12))
+	if r.err != nil || n+3 > len(b) || n < 17 {
+		return nil, ErrFormat
+	}
+	b = b[:n+3]
+	r.b = b
+	if crc32(b) != 0 {
+		return nil, ErrCRC
+	}
+	s.Protocol = uint8(r.read(8))
+	if r.flag() {
+		return nil, ErrEncrypted
+	}
+	r.skip(6) // encryption_algorithm
+	s.PTSAdjust = r.read(33)
+	s.CWIndex = uint8(r.read(8))
+	s.Tier = uint16(r.read(12))
+	cmdlen := int(r.read(12))
+	s.Type = uint8(r.read(8))
+	start := r.off
+	switch s.Type {
+	case Insert:
+		s.Insert = decodeInsert(r)
+	case TimeSignal:
+		t := decodeTime(r)
+		s.Time = &t
+	case Null, BandwidthReservation:
+	default:
+		if cmdlen == 0xfff {
+			return nil, ErrFormat
+		}
+		s.Command = r.bytes(cmdlen)
+	}
+	if cmdlen != 0xfff && r.off-start != cmdlen*8 {
+		return nil, ErrFormat
+	}
+	dlen := int(r.read(16))
+	if r.err != nil || r.left() < dlen+4 {
+		return nil, ErrFormat
+	}
+	d := &reader{b: r.bytes(dlen)}
+	for d.left() > 0 && d.err == nil {
+		s.Descriptor = append(s.Descriptor, decodeDescriptor(d))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return s, r.err
+}
+
+func decodeTime(r *reader) (t SpliceTime) {
+	if t.Specified = r.flag(); t.Specified {
+		r.skip(6)
+		t.PTS = r.read(33)
+	} else {
+		r.skip(7)
+	}
+	return t
+}
+
+func decodeInsert(r *reader) *SpliceInsert {
+	c := &SpliceInsert{EventID: uint32(r.read(32))}
+	c.Cancel = r.flag()
+	r.skip(7)
+	if c.Cancel {
+		return c
+	}
+	c.Out = r.flag()
+	c.Program = r.flag()
+	hasdur := r.flag()
+	c.Immediate = r.flag()
+	r.skip(4)
+	if c.Program && !c.Immediate {
+		c.Time = decodeTime(r)
+	}
+	if !c.Program {
+		for n := r.read(8); n > 0; n-- {
+			p := Component{Tag: uint8(r.read(8))}
+			if !c.Immediate {
+				p.Time = decodeTime(r)
+			}
+			c.Component = append(c.Component, p)
+		}
+	}
+	if hasdur {
+		d := &BreakDuration{AutoReturn: r.flag()}
+		r.skip(6)
+		d.Duration = r.read(33)
+		c.Duration = d
+	}
+	c.ProgramID = uint16(r.read(16))
+	c.AvailNum = uint8(r.read(8))
+	c.AvailCount = uint8(r.read(8))
+	return c
+}
+
+func decodeDescriptor(r *reader) Descriptor {
+	d := Descriptor{Tag: uint8(r.read(8))}
+	n := int(r.read(8))
+	body := r.bytes(n)
+	if len(body) < 4 {
+		r.err = ErrFormat
+		return d
+	}
+	b := &reader{b: body}
+	d.ID = uint32(b.read(32))
+	if d.Tag != SegmentationTag || d.ID != CUEI {
+		d.Data = body[4:]
+		return d
+	}
+	d.Segmentation = decodeSegmentation(b)
+	if b.err != nil {
+		r.err = b.err
+	}
+	return d
+}
+
+func decodeSegmentation(r *reader) *Segmentation {
+	s := &Segmentation{EventID: uint32(r.read(32))}
+	s.Cancel = r.flag()
+	r.skip(7)
+	if s.Cancel {
+		return s
+	}
+	s.Program = r.flag()
+	hasdur := r.flag()
+	s.Restricted = !r.flag()
+	if s.Restricted {
+		s.WebDelivery = r.flag()
+		s.NoRegionalBlackout = r.flag()
+		s.Archive = r.flag()
+		s.DeviceRestrictions = uint8(r.read(2))
+	} else {
+		r.skip(5)
+	}
+	if !s.Program {
+		for n := r.read(8); n > 0; n-- {
+			p := Component{Tag: uint8(r.read(8))}
+			r.skip(7)
+			p.Time = SpliceTime{Specified: true, PTS: r.read(33)}
+			s.Component = append(s.Component, p)
+		}
+	}
+	if hasdur {
+		d := r.read(40)
+		s.Duration = &d
+	}
+	s.UPID.Type = uint8(r.read(8))
+	s.UPID.Value = r.bytes(int(r.read(8)))
+	s.TypeID = uint8(r.read(8))
+	s.Num = uint8(r.read(8))
+	s.Expected = uint8(r.read(8))
+	if r.left() >= 2 {
+		s.Sub = true
+		s.SubNum = uint8(r.read(8))
+		s.SubExpected = uint8(r.read(8))
+	}
+	return s
+}
+
+// Encode returns the binary splice_info_section
+func (s *Splice) Encode() []byte {
+	cmd := &writer{}
+	switch {
+	case s.Insert != nil:
+		s.Insert.encode(cmd)
+	case s.Time != nil:
+		s.Time.encode(cmd)
+	default:
+		cmd.bytes(s.Command)
+	}
+	desc := &writer{}
+	for _, d := range s.Descriptor {
+		d.encode(desc)
+	}
+
+	w := &writer{}
+	w.write(0xfc, 8)
+	w.write(0, 1) // section_syntax_indicator
+	w.write(0, 1) // private_indicator
+	w.write(uint64(s.SAPType), 2)
+	w.write(uint64(11+len(cmd.b)+2+len(desc.b)+4), 12)
+	w.write(uint64(s.Protocol), 8)
+	w.write(0, 1) // encrypted_packet
+	w.write(0, 6) // encryption_algorithm
+	w.write(s.PTSAdjust, 33)
+	w.write(uint64(s.CWIndex), 8)
+	w.write(uint64(s.Tier), 12)
+	w.write(uint64(len(cmd.b)), 12)
+	w.write(uint64(s.typ()), 8)
+	w.bytes(cmd.b)
+	w.write(uint64(len(desc.b)), 16)
+	w.bytes(desc.b)
+	w.write(uint64(crc32(w.b)), 32)
+	return w.b
+}
+
+// Hex returns the section in hex with a 0x prefix, as used by EXT-X-DATERANGE
+func (s *Splice) Hex() string {
+	return "0x" + strings.ToUpper(hex.EncodeToString(s.Encode()))
+}
+
+// Base64 returns the section in base64
+func (s *Splice) Base64() string {
+	return base64.StdEncoding.EncodeToString(s.Encode())
+}
+
+func (s *Splice) typ() uint8 {
+	switch {
+	case s.Insert != nil:
+		return Insert
+	case s.Time != nil:
+		return TimeSignal
+	}
+	return s.Type
+}
+
+func (t SpliceTime) encode(w *writer) {
+	w.flag(t.Specified)
+	if t.Specified {
+		w.reserved(6)
+		w.write(t.PTS, 33)
+	} else {
+		w.reserved(7)
+	}
+}
+
+func (c *SpliceInsert) encode(w *writer) {
+	w.write(uint64(c.EventID), 32)
+	w.flag(c.Cancel)
+	w.reserved(7)
+	if c.Cancel {
+		return
+	}
+	w.flag(c.Out)
+	w.flag(c.Program)
+	w.flag(c.Duration != nil)
+	w.flag(c.Immediate)
+	w.reserved(4)
+	if c.Program && !c.Immediate {
+		c.Time.encode(w)
+	}
+	if !c.Program {
+		w.write(uint64(len(c.Component)), 8)
+		for _, p := range c.Component {
+			w.write(uint64(p.Tag), 8)
+			if !c.Immediate {
+				p.Time.encode(w)
+			}
+		}
+	}
+	if d := c.Duration; d != nil {
+		w.flag(d.AutoReturn)
+		w.reserved(6)
+		w.write(d.Duration, 33)
+	}
+	w.write(uint64(c.ProgramID), 16)
+	w.write(uint64(c.AvailNum), 8)
+	w.write(uint64(c.AvailCount), 8)
+}
+
+func (d Descriptor) encode(w *writer) {
+	body := &writer{}
+	body.write(uint64(d.ID), 32)
+	if d.Segmentation != nil {
+		d.Segmentation.encode(body)
+	} else {
+		body.bytes(d.Data)
+	}
+	w.write(uint64(d.Tag), 8)
+	w.write(uint64(len(body.b)), 8)
+	w.bytes(body.b)
+}
+
+func (s *Segmentation) encode(w *writer) {
+	w.write(uint64(s.EventID), 32)
+	w.flag(s.Cancel)
+	w.reserved(7)
+	if s.Cancel {
+		return
+	}
+	w.flag(s.Program)
+	w.flag(s.Duration != nil)
+	w.flag(!s.Restricted)
+	if s.Restricted {
+		w.flag(s.WebDelivery)
+		w.flag(s.NoRegionalBlackout)
+		w.flag(s.Archive)
+		w.write(uint64(s.DeviceRestrictions), 2)
+	} else {
+		w.reserved(5)
+	}
+	if !s.Program {
+		w.write(uint64(len(s.Component)), 8)
+		for _, p := range s.Component {
+			w.write(uint64(p.Tag), 8)
+			w.reserved(7)
+			w.write(p.Time.PTS, 33)
+		}
+	}
+	if s.Duration != nil {
+		w.write(*s.Duration, 40)
+	}
+	w.write(uint64(s.UPID.Type), 8)
+	w.write(uint64(len(s.UPID.Value)), 8)
+	w.bytes(s.UPID.Value)
+	w.write(uint64(s.TypeID), 8)
+	w.write(uint64(s.Num), 8)
+	w.write(uint64(s.Expected), 8)
+	if s.Sub {
+		w.write(uint64(s.SubNum), 8)
+		w.write(uint64(s.SubExpected), 8)
+	}
+}
+
+// Duration returns the duration of the break signaled by s: the break
+// duration of a splice_insert, or the duration of the first segmentation
+// descriptor that has one. It returns false if there is none.
+func (s *Splice) Duration() (time.Duration, bool) {
+	if s.Insert != nil && s.Insert.Duration != nil {
+		return Ticks(s.Insert.Duration.Duration), true
+	}
+	for _, d := range s.Descriptor {
+		if g := d.Segmentation; g != nil && g.Duration != nil {
+			return Ticks(*g.Duration), true
+		}
+	}
+	return 0, false
+}
+
+// Ticks converts a number of PTS ticks to a duration
+func Ticks(n uint64) time.Duration {
+	return time.Duration(n) * time.Second / Clock
+}
+
+// PTS converts a duration to PTS ticks
+func PTS(d time.Duration) uint64 {
+	return uint64(d * Clock / time.Second)
+}
+
+// NewInsert returns an immediate splice_insert for the event. If out is
+// set, it signals the start of a break of the given duration, which may
+// be zero if unknown, otherwise it signals the end of the break.
+func NewInsert(event uint32, out bool, dur time.Duration) *Splice {
+	c := &SpliceInsert{EventID: event, Out: out, Program: true, Immediate: true}
+	if out && dur > 0 {
+		c.Duration = &BreakDuration{AutoReturn: true, Duration: PTS(dur)}
+	}
+	return &Splice{Type: Insert, Insert: c, Tier: 0xfff}
+}
@@ -0,0 +1,134 @@
+package scte35
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the time_signal and splice_insert samples from section 14 of the standard
+const (
+	sampleTimeSignal = "/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg=="
+	sampleInsert     = "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+)
+
+func TestTimeSignal(t *testing.T) {
+	s, err := Parse(sampleTimeSignal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != TimeSignal || s.Time == nil || s.Time.PTS != 0x072bd0050 {
+		t.Fatalf("bad time_signal: %+v", s)
+	}
+	if len(s.Descriptor) != 1 || s.Descriptor[0].Segmentation == nil {
+		t.Fatalf("missing segmentation descriptor: %+v", s.Descriptor)
+	}
+	g := s.Descriptor[0].Segmentation
+	if g.EventID != 0x4800008e || g.TypeID != ProviderPlacementOpportunity || g.Num != 2 {
+		t.Fatalf("bad segmentation descriptor: %+v", g)
+	}
+	if h, w := g.UPID, (UPID{Type: UPIDTI, Value: []byte{0, 0, 0, 0, 0x2c, 0xa0, 0xa1, 0x8a}}); !reflect.DeepEqual(h, w) {
+		t.Fatalf("upid:\n\t\thave: %v\n\t\twant: %v", h, w)
+	}
+	if d, ok := s.Duration(); !ok || d != 307*time.Second {
+		t.Fatalf("duration: have %v, want %v", d, 307*time.Second)
+	}
+	if h := s.Base64(); h != sampleTimeSignal {
+		t.Fatalf("round trip:\n\t\thave: %s\n\t\twant: %s", h, sampleTimeSignal)
+	}
+}
+
+func TestSpliceInsert(t *testing.T) {
+	s, err := Parse(sampleInsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Insert
+	if c == nil || c.EventID != 0x4800008f || !c.Out || !c.Program || c.Immediate || c.Time.PTS != 0x07369c02e {
+		t.Fatalf("bad splice_insert: %+v", c)
+	}
+	if c.Duration == nil || !c.Duration.AutoReturn || c.Duration.Duration != 0x00052ccf5 {
+		t.Fatalf("bad break duration: %+v", c.Duration)
+	}
+	if d := s.Descriptor; len(d) != 1 || d[0].Tag != AvailTag || !bytes.Equal(d[0].Data, []byte{0, 0, 1, 0x35}) {
+		t.Fatalf("bad avail descriptor: %+v", d)
+	}
+
+	// the same section in the other forms
+	for _, form := range []string{s.Hex(), string(s.Encode())} {
+		h, err := Parse(form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(h, s) {
+			t.Fatalf("mismatch:\n\t\thave: %+v\n\t\twant: %+v", h, s)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	b := (&Splice{Insert: &SpliceInsert{EventID: 1, Cancel: true}}).Encode()
+	if _, err := Decode(b); err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-5] ^= 1
+	if _, err := Decode(b); err != ErrCRC {
+		t.Fatalf("have %v, want %v", err, ErrCRC)
+	}
+	if _, err := Decode(b[:10]); err != ErrFormat {
+		t.Fatalf("have %v, want %v", err, ErrFormat)
+	}
+	if _, err := Parse("0xFD00"); err != ErrTable {
+		t.Fatalf("have %v, want %v", err, ErrTable)
+	}
+}
+
+func TestNewInsert(t *testing.T) {
+	s, err := Parse(NewInsert(7, true, 30*time.Second).Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Insert; c.EventID != 7 || !c.Out || !c.Immediate {
+		t.Fatalf("bad splice_insert: %+v", c)
+	}
+	if d, ok := s.Duration(); !ok || d != 30*time.Second {
+		t.Fatalf("duration: have %v, want %v", d, 30*time.Second)
+	}
+}
+
+func TestSegmentationMID(t *testing.T) {
+	dur := PTS(15 * time.Second)
+	mid := UPID{Type: UPIDMID, Value: []byte{UPIDAdID, 3, 'a', 'b', 'c', UPIDURI, 2, 'x', 'y'}}
+	s := &Splice{
+		Time: &SpliceTime{Specified: true, PTS: 1234},
+		Descriptor: []Descriptor{{
+			Tag: SegmentationTag,
+			ID:  CUEI,
+			Segmentation: &Segmentation{
+				EventID:   1,
+				Component: []Component{{Tag: 1, Time: SpliceTime{Specified: true, PTS: 5}}},
+				Duration:  &dur,
+				UPID:      mid,
+				TypeID:    DistributorAdStart,
+				Sub:       true,
+				SubNum:    1,
+			},
+		}},
+	}
+	h, err := Decode(s.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Type = TimeSignal
+	if !reflect.DeepEqual(h, s) {
+		t.Fatalf("mismatch:\n\t\thave: %+v\n\t\twant: %+v", h.Descriptor[0].Segmentation, s.Descriptor[0].Segmentation)
+	}
+	sub, err := h.Descriptor[0].Segmentation.UPID.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub) != 2 || sub[0].String() != "abc" || sub[1].String() != "xy" {
+		t.Fatalf("bad mid: %v", sub)
+	}
+}
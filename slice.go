@@ -0,0 +1,75 @@
+package hls
+
+import "time"
+
+// Slice returns the segments of m that overlap the time range [from, to),
+// where the times are offsets from the start of the playlist. See slice for
+// how the playlist is adjusted.
+func (m Media) Slice(from, to time.Duration) Media {
+	ts := m.Timeline()
+	i, j := span(ts, func(s Span) bool {
+		return s.End() > from && s.Start < to
+	})
+	return m.slice(ts, i, j)
+}
+
+// SliceTime is like Slice, except the range is given in wall-clock time,
+// as determined by the EXT-X-PROGRAM-DATE-TIME tags in the playlist. If
+// there are no such tags the result is empty.
+func (m Media) SliceTime(from, to time.Time) Media {
+	ts := m.Timeline()
+	i, j := span(ts, func(s Span) bool {
+		return !s.Time.IsZero() && s.Time.Add(s.Duration).After(from) && s.Time.Before(to)
+	})
+	return m.slice(ts, i, j)
+}
+
+// SliceSeq is like Slice, except the range [from, to) is given in media
+// sequence numbers.
+func (m Media) SliceSeq(from, to int) Media {
+	ts := m.Timeline()
+	i, j := span(ts, func(s Span) bool {
+		return s.Seq >= from && s.Seq < to
+	})
+	return m.slice(ts, i, j)
+}
+
+// span returns the range of spans in ts for which fn is true. Since
+// the spans are ordered, the range is contiguous.
+func span(ts []Span, fn func(Span) bool) (i, j int) {
+	for i = 0; i < len(ts) && !fn(ts[i]); i++ {
+	}
+	for j = i; j < len(ts) && fn(ts[j]); j++ {
+	}
+	return i, j
+}
+
+// slice returns a copy of m containing the segments m.File[i:j].
+//
+// The media and discontinuity sequence numbers are set to those of the
+// new first segment and it's given a program date time if the playlist
+// has them. The first segment always carries its EXT-X-MAP and EXT-X-KEY
+// tags, since the encoder emits sticky tags when they first appear.
+// Partial segments, preload hints and rendition reports are only kept
+// if the slice extends to the end of the playlist. The playlist type and
+// EXT-X-ENDLIST are kept, so a slice of a VOD playlist is a VOD playlist.
+func (m Media) slice(ts []Span, i, j int) Media {
+	switch {
+	case i < len(ts):
+		m.Sequence, m.Discontinuity = ts[i].Seq, ts[i].Discontinuity
+	case len(ts) > 0:
+		m.Sequence, m.Discontinuity = ts[len(ts)-1].Seq+1, ts[len(ts)-1].Discontinuity
+	default:
+		m.Sequence = m.Seq(0)
+	}
+	m.Skip = Skip{}
+	if j < len(m.File) {
+		m.Part, m.Hint, m.Report = nil, nil, nil
+	}
+	m.File = append([]File{}, m.File[i:j]...)
+	if len(m.File) > 0 {
+		m.File[0].Discontinuous = false
+		m.File[0].Time = ts[i].Time
+	}
+	return m
+}
@@ -0,0 +1,215 @@
+package hls
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Splicer inserts ad pods into a content playlist at its cue points. It
+// replaces the content segments between EXT-X-CUE-OUT and EXT-X-CUE-IN with
+// the segments of the pod returned by Pod. If the break has a duration, the
+// pod is trimmed to it, and if the pod is too short the remainder of the
+// break is filled with the original content.
+//
+// Discontinuities are inserted where the output switches between content
+// and ads, and the ad segments carry EXT-X-CUE-OUT and EXT-X-CUE-OUT-CONT
+// tags with the elapsed time of the break.
+//
+// A Splicer remembers what it spliced, so the successive reloads of a live
+// playlist can be passed to Splice and the output has consistent media and
+// discontinuity sequence numbers. It's safe for concurrent use.
+type Splicer struct {
+	// Pod returns the ad pod for the break. It's called once per break,
+	// and the URIs in the pod are resolved against its URL.
+	Pod func(b Break) (Media, error)
+
+	mu    sync.Mutex
+	group map[int]*group // by content sequence number
+}
+
+// splice is a break and the ad segments that fill it
+type splice struct {
+	Break
+	ad []File
+	at []time.Duration // offset of each ad segment in the break
+	n  time.Duration   // total duration of the ads
+}
+
+// group is the output of a single content segment, which is either
+// the segment itself, or the ad segments that start during it
+type group struct {
+	seq  int // output sequence number of the first file
+	disc int // output discontinuity sequence before the first file
+	file []File
+
+	brk *splice       // nil if the segment isn't in a break
+	off time.Duration // offset of the segment in the break
+	end time.Duration // offset of the end of the segment in the break
+	ad  bool          // the last output of the group is an ad
+}
+
+func (g *group) next() (seq, disc int) {
+	seq, disc = g.seq+len(g.file), g.disc
+	for _, f := range g.file {
+		if f.Discontinuous {
+			disc++
+		}
+	}
+	return seq, disc
+}
+
+// Splice returns the content playlist with the ad pods spliced in. It
+// returns ErrSkip if content is a delta update.
+func (s *Splicer) Splice(content Media) (Media, error) {
+	if content.Skip.Segments != 0 {
+		return Media{}, ErrSkip
+	}
+	if s.Pod == nil {
+		return Media{}, errors.New("hls: splicer has no pod func")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.group == nil {
+		s.group = map[int]*group{}
+	}
+	ts := content.Timeline()
+	out := content
+	out.File = nil
+	for i, f := range content.File {
+		seq := ts[i].Seq
+		g := s.group[seq]
+		if g == nil {
+			var err error
+			if g, err = s.splice(f, ts[i], s.group[seq-1]); err != nil {
+				return Media{}, err
+			}
+			s.group[seq] = g
+		}
+		if i == 0 {
+			out.Sequence, out.Discontinuity = g.seq, g.disc
+		}
+		out.File = append(out.File, g.file...)
+	}
+	for seq := range s.group {
+		if seq < content.Sequence-1 {
+			delete(s.group, seq)
+		}
+	}
+	for _, f := range out.File {
+		if d := f.Inf.Duration.Round(time.Second); d > out.Target {
+			out.Target = d
+		}
+	}
+	if v := out.MinVersion(); out.Version != 0 && out.Version < v {
+		out.Version = v
+	}
+	return out, nil
+}
+
+// splice computes the output of the content segment f, given the output
+// of the segment before it. If prev is nil the splicer hasn't seen the
+// segment before f, so the output sequence numbers are taken from the
+// content playlist.
+func (s *Splicer) splice(f File, span Span, prev *group) (*group, error) {
+	g := &group{seq: span.Seq, disc: span.Discontinuity}
+	if f.Discontinuous {
+		g.disc--
+	}
+	if prev != nil {
+		g.seq, g.disc = prev.next()
+	}
+
+	// find the break the segment belongs to, and its offset in it
+	cue := f.AD.Cue()
+	switch {
+	case cue.Kind == "out":
+		g.brk = &splice{Break: Break{ID: cue.ID, Seq: span.Seq, Duration: cue.Duration}}
+	case prev != nil && prev.brk != nil:
+		if cue.Kind != "in" && (prev.brk.Duration == 0 || prev.end < prev.brk.Duration) {
+			g.brk, g.off = prev.brk, prev.end
+		}
+	case cue.Kind == "cont":
+		// joined in the middle of a break
+		g.brk = &splice{Break: Break{ID: cue.ID, Seq: span.Seq, Duration: cue.Duration}}
+		g.off = cue.Elapsed
+	}
+	lastad := prev != nil && prev.ad
+
+	if g.brk == nil {
+		if lastad {
+			f.Discontinuous = true
+		}
+		if prev != nil && prev.brk != nil && (f.AD == nil || !f.AD.CueIn.IsAD()) {
+			ad := AD{}
+			if f.AD != nil {
+				ad = *f.AD
+			}
+			ad.CueIn = Cue{Set: true}
+			f.AD = &ad
+		}
+		g.file = []File{f}
+		return g, nil
+	}
+	b := g.brk
+	if b.ad == nil {
+		if err := s.fill(b); err != nil {
+			return nil, err
+		}
+	}
+	g.end = g.off + span.Duration
+	cont := func(f File, elapsed time.Duration) File {
+		f.AD = &AD{CueCont: Cue{Duration: b.Duration, Elapsed: elapsed, Set: true}}
+		return f
+	}
+
+	// the ads are exhausted, so the content fills the rest of the break
+	if g.off >= b.n {
+		f = cont(f, g.off)
+		f.Discontinuous = f.Discontinuous || lastad
+		g.file = []File{f}
+		return g, nil
+	}
+	for k, ad := range b.ad {
+		if b.at[k] < g.off || b.at[k] >= g.end {
+			continue
+		}
+		switch {
+		case b.at[k] == 0:
+			ad.AD = &AD{CueOut: Cue{Duration: b.Duration, ID: b.ID, Set: true}}
+		default:
+			ad = cont(ad, b.at[k])
+		}
+		if !lastad || b.at[k] == 0 {
+			ad.Discontinuous = true
+		}
+		lastad = true
+		g.file = append(g.file, ad)
+	}
+	g.ad = true
+	return g, nil
+}
+
+// fill fetches the pod for the break and trims it to the break duration
+func (s *Splicer) fill(b *splice) error {
+	pod, err := s.Pod(b.Break)
+	if err != nil {
+		return err
+	}
+	b.ad, b.at = []File{}, []time.Duration{}
+	for _, f := range pod.File {
+		if b.Duration > 0 && b.n >= b.Duration {
+			break
+		}
+		f = f.resolve(pod.URL)
+		f.AD, f.Time = nil, time.Time{}
+		f.Inf.Duration = f.Duration(pod.Target)
+		if b.Duration > 0 && b.n+f.Inf.Duration > b.Duration {
+			f.Inf.Duration = b.Duration - b.n
+		}
+		b.ad = append(b.ad, f)
+		b.at = append(b.at, b.n)
+		b.n += f.Inf.Duration
+	}
+	return nil
+}
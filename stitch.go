@@ -0,0 +1,98 @@
+package hls
+
+import "time"
+
+// Stitch joins the media playlists in m into a single playlist, such as
+// pre-roll, content and post-roll VODs. The URIs in each playlist are
+// resolved against its own URL, so the result can be served from anywhere
+// if the URLs are absolute.
+//
+// An EXT-X-DISCONTINUITY tag is inserted at the boundary between two
+// playlists unless the segments on either side share the same EXT-X-MAP
+// and EXT-X-KEY tags and their EXT-X-PROGRAM-DATE-TIME tags prove the
+// timestamps are continuous. Media playlists don't declare their codecs,
+// so a boundary without that proof is treated as a codec change.
+//
+// The header is taken from the first playlist, except for the target
+// duration, which is recomputed from the segments, and the tags after the
+// last segment, which are taken from the last playlist. Stitch returns
+// ErrEmpty if there are no playlists and ErrSkip if one is a delta update.
+func Stitch(m ...Media) (Media, error) {
+	if len(m) == 0 {
+		return Media{}, ErrEmpty
+	}
+	last := m[len(m)-1]
+	dst := Media{MediaHeader: m[0].MediaHeader}
+	dst.Target = 0
+	dst.End, dst.Hint, dst.Report = last.End, last.Hint, last.Report
+	end := time.Time{} // wall-clock end of the previous segment
+	for _, src := range m {
+		if src.Skip.Segments != 0 {
+			return Media{}, ErrSkip
+		}
+		if src.Version > dst.Version {
+			dst.Version = src.Version
+		}
+		ts := src.Timeline()
+		for i, f := range src.File {
+			f = f.resolve(src.URL)
+			if i == 0 && len(dst.File) > 0 && !continuous(dst.File[len(dst.File)-1], f, end, ts[i].Time) {
+				f.Discontinuous = true
+			}
+			end = time.Time{}
+			if !ts[i].Time.IsZero() {
+				end = ts[i].Time.Add(ts[i].Duration)
+			}
+			if d := f.Inf.Duration.Round(time.Second); d > dst.Target {
+				dst.Target = d
+			}
+			dst.File = append(dst.File, f)
+		}
+	}
+	for _, p := range last.Part {
+		p.URI = pathof(last.URL, p.URI)
+		dst.Part = append(dst.Part, p)
+	}
+	if dst.Target == 0 {
+		dst.Target = m[0].Target
+	}
+	if v := dst.MinVersion(); dst.Version != 0 && dst.Version < v {
+		dst.Version = v
+	}
+	return dst, nil
+}
+
+// continuous returns true if the segment g can follow f without a
+// discontinuity. The wall-clock time f ends must be the time g starts.
+func continuous(f, g File, end, start time.Time) bool {
+	if f.Map != g.Map || !f.Key.Equal(g.Key) {
+		return false
+	}
+	return !end.IsZero() && end.Equal(start)
+}
+
+// resolve returns a copy of f with its URIs resolved against parent
+func (f File) resolve(parent string) File {
+	if parent == "" {
+		return f
+	}
+	f.Inf.URL = pathof(parent, f.Inf.URL)
+	f.Map.URI = pathof(parent, f.Map.URI)
+	if len(f.Key) > 0 {
+		key := make(Keys, len(f.Key))
+		for i, k := range f.Key {
+			k.URI = pathof(parent, k.URI)
+			key[i] = k
+		}
+		f.Key = key
+	}
+	if len(f.Part) > 0 {
+		part := make([]Part, len(f.Part))
+		for i, p := range f.Part {
+			p.URI = pathof(parent, p.URI)
+			part[i] = p
+		}
+		f.Part = part
+	}
+	return f
+}
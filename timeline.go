@@ -0,0 +1,74 @@
+package hls
+
+import "time"
+
+// Span is the position of a segment on the timeline of a media playlist
+type Span struct {
+	Seq           int           // media sequence number
+	Discontinuity int           // discontinuity sequence number
+	Start         time.Duration // offset from the start of the playlist
+	Duration      time.Duration
+
+	// Time is the wall-clock time of the segment. It's interpolated from
+	// the nearest EXT-X-PROGRAM-DATE-TIME tag, preferring tags within the
+	// same discontinuity. It's zero if the playlist has no such tags.
+	Time time.Time
+}
+
+// End returns the offset of the end of the segment
+func (s Span) End() time.Duration {
+	return s.Start + s.Duration
+}
+
+// Timeline returns the span of every segment in m.File. The offsets are
+// relative to the first segment in m.File, so segments skipped by a delta
+// update don't contribute to them. Segments without a duration are
+// assumed to last for the target duration.
+func (m Media) Timeline() []Span {
+	ts := make([]Span, len(m.File))
+	pdt := []int{}
+	start, disc := time.Duration(0), m.Discontinuity
+	for i, f := range m.File {
+		if f.Discontinuous {
+			disc++
+		}
+		d := f.Duration(m.Target)
+		ts[i] = Span{Seq: m.Seq(i), Discontinuity: disc, Start: start, Duration: d, Time: f.Time}
+		start += d
+		if !f.Time.IsZero() {
+			pdt = append(pdt, i)
+		}
+	}
+	if len(pdt) == 0 {
+		return ts
+	}
+	for i := range ts {
+		if !ts[i].Time.IsZero() {
+			continue
+		}
+		j := nearest(ts, pdt, i)
+		ts[i].Time = ts[j].Time.Add(ts[i].Start - ts[j].Start)
+	}
+	return ts
+}
+
+// nearest returns the index of the span in pdt closest to span i,
+// preferring one in the same discontinuity
+func nearest(ts []Span, pdt []int, i int) int {
+	best, same := -1, false
+	dist := func(j int) time.Duration {
+		d := ts[i].Start - ts[j].Start
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	for _, j := range pdt {
+		s := ts[j].Discontinuity == ts[i].Discontinuity
+		switch {
+		case best == -1, s && !same, s == same && dist(j) < dist(best):
+			best, same = j, s
+		}
+	}
+	return best
+}
@@ -0,0 +1,423 @@
+package hls
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Severity is the requirement level of a validation rule
+type Severity int
+
+const (
+	Must   Severity = iota // players are allowed to reject the playlist
+	Should                 // players are expected to cope, but may not
+)
+
+func (s Severity) String() string {
+	if s == Should {
+		return "SHOULD"
+	}
+	return "MUST"
+}
+
+// Finding is a violation of a rule in RFC 8216bis. Section is the section
+// of the draft that states the rule, or empty for rules that come from
+// common practice rather than the draft. Tag is the tag that violates it
+// and Index is the position of that tag's element in its list (Media.File,
+// Master.Stream, Master.Media, etc), or -1 if the tag is in the header.
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis
+type Finding struct {
+	Rule     string
+	Severity Severity
+	Section  string
+	Tag      string
+	Index    int
+	Msg      string
+}
+
+func (f Finding) String() string {
+	s := f.Tag
+	if f.Index >= 0 {
+		s = fmt.Sprintf("%s[%d]", s, f.Index)
+	}
+	if f.Section != "" {
+		s += ", section " + f.Section
+	}
+	return fmt.Sprintf("%s %s (%s): %s", f.Severity, f.Rule, s, f.Msg)
+}
+
+// findings accumulates the results of the validator
+type findings []Finding
+
+func (fs *findings) add(sev Severity, rule, section, tag string, index int, format string, arg ...any) {
+	*fs = append(*fs, Finding{
+		Rule:     rule,
+		Severity: sev,
+		Section:  section,
+		Tag:      tag,
+		Index:    index,
+		Msg:      fmt.Sprintf(format, arg...),
+	})
+}
+
+var hexseq = regexp.MustCompile(`^0[xX][0-9a-fA-F]+$`)
+
+// Validate checks the playlist against the MUST and SHOULD rules of
+// RFC 8216bis that apply to media playlists. It returns nil if no rule
+// is violated. Validate doesn't modify the playlist or resolve its
+// variables.
+func (m Media) Validate() []Finding {
+	var fs findings
+	fs.header(m.M3U, m.Define)
+	if v, why := m.minversion(); m.Version != 0 && m.Version < v {
+		fs.add(Must, "version", "8", "EXT-X-VERSION", -1, "version %d, but %s requires version %d", m.Version, why, v)
+	}
+	if m.Target <= 0 {
+		fs.add(Must, "target-duration", "4.4.3.1", "EXT-X-TARGETDURATION", -1, "missing target duration")
+	}
+	if m.Target%time.Second != 0 {
+		fs.add(Must, "target-duration-integer", "4.4.3.1", "EXT-X-TARGETDURATION", -1, "target duration %s is not a whole number of seconds", m.Target)
+	}
+	if t := m.Type; t != "" && t != Vod && t != Event {
+		fs.add(Must, "playlist-type", "4.4.3.5", "EXT-X-PLAYLIST-TYPE", -1, "unknown playlist type %q", t)
+	}
+	if m.Start.Offset != 0 {
+		off := m.Start.Offset
+		if off < 0 {
+			off = -off
+		}
+		if rt := Runtime(m.File...); off > rt {
+			fs.add(Should, "start-offset", "4.4.2.2", "EXT-X-START", -1, "offset %s is longer than the playlist (%s)", m.Start.Offset, rt)
+		}
+	}
+	m.validateControl(&fs)
+
+	pdt := false
+	for i, f := range m.File {
+		pdt = pdt || !f.Time.IsZero()
+		if f.Inf.URL == "" {
+			fs.add(Must, "segment-uri", "4.4.4.1", "EXTINF", i, "segment has no uri")
+		}
+		if f.Inf.Duration < 0 {
+			fs.add(Must, "segment-duration", "4.4.4.1", "EXTINF", i, "negative duration %s", f.Inf.Duration)
+		}
+		if d := f.Inf.Duration.Round(time.Second); m.Target > 0 && d > m.Target {
+			fs.add(Must, "segment-target-duration", "4.4.3.1", "EXTINF", i, "duration %s rounds to %s, which exceeds the target duration %s", f.Inf.Duration, d, m.Target)
+		}
+		if f.Range.V != "" {
+			_, _, err := f.Range.Value(0)
+			switch {
+			case err != nil:
+				fs.add(Must, "byterange", "4.4.4.2", "EXT-X-BYTERANGE", i, "malformed byte range %q", f.Range.V)
+			case !strings.Contains(f.Range.V, "@") && (i == 0 || m.File[i-1].Range.V == "" || m.File[i-1].Inf.URL != f.Inf.URL):
+				fs.add(Must, "byterange-offset", "4.4.4.2", "EXT-X-BYTERANGE", i, "byte range has no offset, but the previous segment is not a sub-range of %q", f.Inf.URL)
+			}
+		}
+		if f.Map != (Map{}) && f.Map.URI == "" {
+			fs.add(Must, "map-uri", "4.4.4.5", "EXT-X-MAP", i, "map has no uri")
+		}
+		if i == 0 || !f.Key.Equal(m.File[i-1].Key) {
+			fs.keys(f.Key, "EXT-X-KEY", i, false)
+		}
+		if f.AD != nil && !f.AD.DateRange.IsZero() {
+			fs.daterange(f.AD.DateRange, i)
+		}
+		for j, p := range f.Part {
+			m.validatePart(&fs, p, i, j)
+		}
+	}
+	for j, p := range m.Part {
+		m.validatePart(&fs, p, len(m.File), j)
+	}
+	if !pdt {
+		for i, f := range m.File {
+			if f.AD != nil && !f.AD.DateRange.IsZero() {
+				fs.add(Must, "daterange-pdt", "4.4.5.1", "EXT-X-DATERANGE", i, "playlist has date ranges, but no EXT-X-PROGRAM-DATE-TIME")
+				break
+			}
+		}
+	}
+	m.daterangeindex(&fs)
+	return fs
+}
+
+func (m Media) validateControl(fs *findings) {
+	c := m.Control
+	if c.CanSkipUntil > 0 && c.CanSkipUntil < 6*m.Target {
+		fs.add(Must, "can-skip-until", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "CAN-SKIP-UNTIL %s is less than six target durations", c.CanSkipUntil)
+	}
+	if c.CanSkipDateRanges && c.CanSkipUntil == 0 {
+		fs.add(Must, "can-skip-dateranges", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "CAN-SKIP-DATERANGES without CAN-SKIP-UNTIL")
+	}
+	if c.HoldBack > 0 && c.HoldBack < 3*m.Target {
+		fs.add(Must, "hold-back", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "HOLD-BACK %s is less than three target durations", c.HoldBack)
+	}
+	hasparts := len(m.Part) > 0
+	for _, f := range m.File {
+		hasparts = hasparts || len(f.Part) > 0
+	}
+	if hasparts && m.PartInf.Target <= 0 {
+		fs.add(Must, "part-inf", "4.4.3.7", "EXT-X-PART-INF", -1, "playlist has partial segments, but no EXT-X-PART-INF")
+	}
+	if m.PartInf.Target > 0 {
+		switch {
+		case c.PartHoldBack == 0:
+			fs.add(Must, "part-hold-back", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "playlist has EXT-X-PART-INF, but no PART-HOLD-BACK")
+		case c.PartHoldBack < 2*m.PartInf.Target:
+			fs.add(Must, "part-hold-back", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "PART-HOLD-BACK %s is less than twice the part target %s", c.PartHoldBack, m.PartInf.Target)
+		case c.PartHoldBack < 3*m.PartInf.Target:
+			fs.add(Should, "part-hold-back", "4.4.3.8", "EXT-X-SERVER-CONTROL", -1, "PART-HOLD-BACK %s is less than three times the part target %s", c.PartHoldBack, m.PartInf.Target)
+		}
+	}
+}
+
+// validatePart checks the j-th part of the i-th segment
+func (m Media) validatePart(fs *findings, p Part, i, j int) {
+	if p.URI == "" {
+		fs.add(Must, "part-uri", "4.4.4.9", "EXT-X-PART", i, "part %d has no uri", j)
+	}
+	if t := m.PartInf.Target; t > 0 && p.Duration > t {
+		fs.add(Must, "part-target-duration", "4.4.4.9", "EXT-X-PART", i, "part %d duration %s exceeds the part target %s", j, p.Duration, t)
+	}
+}
+
+// Validate checks the playlist against the MUST and SHOULD rules of
+// RFC 8216bis that apply to master playlists. It returns nil if no rule
+// is violated.
+func (m Master) Validate() []Finding {
+	var fs findings
+	fs.header(m.M3U, m.Define)
+	if v, why := m.minversion(); m.Version != 0 && m.Version < v {
+		fs.add(Must, "version", "8", "EXT-X-VERSION", -1, "version %d, but %s requires version %d", m.Version, why, v)
+	}
+	fs.keys(m.SessionKey, "EXT-X-SESSION-KEY", -1, true)
+	m.validateSessionData(&fs)
+	m.validateMedia(&fs)
+
+	if len(m.Stream) == 0 {
+		fs.add(Must, "stream", "4.4.6.2", "EXT-X-STREAM-INF", -1, "master playlist has no variant streams")
+	}
+	nocc := 0
+	for i, s := range m.Stream {
+		if s.URL == "" {
+			fs.add(Must, "stream-uri", "4.4.6.2", "EXT-X-STREAM-INF", i, "variant stream has no uri")
+		}
+		m.validateStream(&fs, s, "EXT-X-STREAM-INF", i)
+		for _, ref := range []struct{ kind, group string }{
+			{"AUDIO", s.Audio},
+			{"VIDEO", s.Video},
+			{"SUBTITLES", s.Subtitle},
+			{"CLOSED-CAPTIONS", s.Caption},
+		} {
+			if ref.group == "" || ref.kind == "CLOSED-CAPTIONS" && ref.group == "NONE" {
+				continue
+			}
+			if !m.hasgroup(ref.kind, ref.group) {
+				fs.add(Must, "stream-group", "4.4.6.2", "EXT-X-STREAM-INF", i, "%s group %q has no EXT-X-MEDIA of TYPE=%s", ref.kind, ref.group, ref.kind)
+			}
+		}
+		if s.Caption == "NONE" {
+			nocc++
+		}
+	}
+	if nocc > 0 && nocc < len(m.Stream) {
+		fs.add(Must, "stream-closed-captions", "4.4.6.2", "EXT-X-STREAM-INF", -1, "CLOSED-CAPTIONS=NONE must be set on every variant stream, not just %d of %d", nocc, len(m.Stream))
+	}
+	for i, s := range m.IFrame {
+		if s.URI == "" {
+			fs.add(Must, "iframe-uri", "4.4.6.3", "EXT-X-I-FRAME-STREAM-INF", i, "i-frame stream has no uri")
+		}
+		m.validateStream(&fs, s, "EXT-X-I-FRAME-STREAM-INF", i)
+	}
+	return fs
+}
+
+var videocodec = []string{"avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe", "av01", "vp09"}
+
+func (m Master) validateStream(fs *findings, s StreamInfo, tag string, i int) {
+	if s.Bandwidth <= 0 {
+		fs.add(Must, "stream-bandwidth", "4.4.6.2", tag, i, "missing BANDWIDTH")
+	}
+	if s.BandwidthAvg > 0 && s.Bandwidth > 0 && s.BandwidthAvg > s.Bandwidth {
+		fs.add(Should, "stream-average-bandwidth", "4.4.6.2", tag, i, "AVERAGE-BANDWIDTH %d exceeds BANDWIDTH %d", s.BandwidthAvg, s.Bandwidth)
+	}
+	if len(s.Codecs) == 0 {
+		fs.add(Should, "stream-codecs", "4.4.6.2", tag, i, "missing CODECS")
+	}
+	if s.Resolution.X == 0 && s.Resolution.Y == 0 {
+		for _, c := range s.Codecs {
+			if hasprefix(c, videocodec...) {
+				fs.add(Should, "stream-resolution", "4.4.6.2", tag, i, "variant has video codec %s, but no RESOLUTION", c)
+				break
+			}
+		}
+	}
+}
+
+func (m Master) validateMedia(fs *findings) {
+	type group struct{ kind, id string }
+	def := map[group]int{}
+	names := map[group]map[string]bool{}
+	for i, v := range m.Media {
+		switch v.Type {
+		case "AUDIO", "VIDEO", "SUBTITLES", "CLOSED-CAPTIONS":
+		case "":
+			fs.add(Must, "media-type", "4.4.6.1", "EXT-X-MEDIA", i, "missing TYPE")
+		default:
+			fs.add(Must, "media-type", "4.4.6.1", "EXT-X-MEDIA", i, "unknown TYPE %q", v.Type)
+		}
+		if v.Group == "" {
+			fs.add(Must, "media-group-id", "4.4.6.1", "EXT-X-MEDIA", i, "missing GROUP-ID")
+		}
+		if v.Name == "" {
+			fs.add(Must, "media-name", "4.4.6.1", "EXT-X-MEDIA", i, "missing NAME")
+		}
+		switch {
+		case v.Type == "SUBTITLES" && v.URI == "":
+			fs.add(Must, "media-uri", "4.4.6.1", "EXT-X-MEDIA", i, "SUBTITLES rendition has no URI")
+		case v.Type == "CLOSED-CAPTIONS" && v.URI != "":
+			fs.add(Must, "media-uri", "4.4.6.1", "EXT-X-MEDIA", i, "CLOSED-CAPTIONS rendition must not have a URI")
+		}
+		switch {
+		case v.Type == "CLOSED-CAPTIONS" && v.Instream == "":
+			fs.add(Must, "media-instream-id", "4.4.6.1", "EXT-X-MEDIA", i, "CLOSED-CAPTIONS rendition has no INSTREAM-ID")
+		case v.Type != "CLOSED-CAPTIONS" && v.Instream != "":
+			fs.add(Must, "media-instream-id", "4.4.6.1", "EXT-X-MEDIA", i, "INSTREAM-ID is only allowed for CLOSED-CAPTIONS")
+		}
+		if v.Default && !v.Autoselect {
+			fs.add(Must, "media-autoselect", "4.4.6.1", "EXT-X-MEDIA", i, "DEFAULT=YES requires AUTOSELECT=YES")
+		}
+		g := group{v.Type, v.Group}
+		if v.Default {
+			if def[g]++; def[g] == 2 {
+				fs.add(Must, "media-default", "4.4.6.1.1", "EXT-X-MEDIA", i, "more than one rendition in %s group %q has DEFAULT=YES", v.Type, v.Group)
+			}
+		}
+		if names[g] == nil {
+			names[g] = map[string]bool{}
+		}
+		if v.Name != "" && names[g][v.Name] {
+			fs.add(Must, "media-name-unique", "4.4.6.1.1", "EXT-X-MEDIA", i, "NAME %q is used more than once in %s group %q", v.Name, v.Type, v.Group)
+		}
+		names[g][v.Name] = true
+	}
+}
+
+func (m Master) validateSessionData(fs *findings) {
+	type id struct{ id, lang string }
+	seen := map[id]bool{}
+	for i, d := range m.SessionData {
+		if d.ID == "" {
+			fs.add(Must, "session-data-id", "4.4.6.4", "EXT-X-SESSION-DATA", i, "missing DATA-ID")
+		}
+		if (d.Value == "") == (d.URI == "") {
+			fs.add(Must, "session-data-value", "4.4.6.4", "EXT-X-SESSION-DATA", i, "exactly one of VALUE or URI must be set")
+		}
+		if k := (id{d.ID, d.Lang}); seen[k] {
+			fs.add(Must, "session-data-unique", "4.4.6.4", "EXT-X-SESSION-DATA", i, "DATA-ID %q with LANGUAGE %q appears more than once", d.ID, d.Lang)
+		} else {
+			seen[k] = true
+		}
+	}
+}
+
+func (m Master) hasgroup(kind, id string) bool {
+	for _, v := range m.Media {
+		if v.Type == kind && v.Group == id {
+			return true
+		}
+	}
+	return false
+}
+
+// header checks the rules common to both playlist types
+func (fs *findings) header(m3u bool, def []Define) {
+	if !m3u {
+		fs.add(Must, "extm3u", "4.4.1.1", "EXTM3U", -1, "missing EXTM3U header")
+	}
+	seen := map[string]bool{}
+	for i, d := range def {
+		name := d.Name
+		switch {
+		case d.Import != "":
+			name = d.Import
+		case d.Query != "":
+			name = d.Query
+		}
+		if name == "" {
+			fs.add(Must, "define-name", "4.4.2.3", "EXT-X-DEFINE", i, "variable has no name")
+			continue
+		}
+		if seen[name] {
+			fs.add(Must, "define-unique", "4.4.2.3", "EXT-X-DEFINE", i, "variable %s is defined more than once", name)
+		}
+		seen[name] = true
+	}
+}
+
+// keys checks the set of keys in an EXT-X-KEY or EXT-X-SESSION-KEY tag
+func (fs *findings) keys(k Keys, tag string, i int, session bool) {
+	for _, k := range k {
+		switch k.Method {
+		case "AES-128", "SAMPLE-AES", "SAMPLE-AES-CTR":
+		case "NONE":
+			if session {
+				fs.add(Must, "key-method", "4.4.6.5", tag, i, "METHOD must not be NONE")
+			}
+			continue
+		case "":
+			fs.add(Must, "key-method", "4.4.4.4", tag, i, "missing METHOD")
+		default:
+			fs.add(Must, "key-method", "4.4.4.4", tag, i, "unknown METHOD %q", k.Method)
+		}
+		if k.URI == "" {
+			fs.add(Must, "key-uri", "4.4.4.4", tag, i, "METHOD=%s requires a URI", k.Method)
+		}
+		if k.IV != "" && !hexseq.MatchString(k.IV) {
+			fs.add(Must, "key-iv", "4.4.4.4", tag, i, "IV %q is not a hexadecimal sequence", k.IV)
+		}
+	}
+}
+
+func (fs *findings) daterange(d DateRange, i int) {
+	const tag = "EXT-X-DATERANGE"
+	if d.ID == "" {
+		fs.add(Must, "daterange-id", "4.4.5.1", tag, i, "missing ID")
+	}
+	if d.Start.IsZero() {
+		fs.add(Must, "daterange-start", "4.4.5.1", tag, i, "missing START-DATE")
+	}
+	if d.Duration < 0 || d.Planned < 0 {
+		fs.add(Must, "daterange-duration", "4.4.5.1", tag, i, "negative DURATION or PLANNED-DURATION")
+	}
+	if !d.End.IsZero() && !d.Start.IsZero() {
+		if d.End.Before(d.Start) {
+			fs.add(Must, "daterange-end", "4.4.5.1", tag, i, "END-DATE is before START-DATE")
+		} else if d.Duration != 0 && d.End.Sub(d.Start) != d.Duration {
+			fs.add(Must, "daterange-end", "4.4.5.1", tag, i, "END-DATE is not START-DATE plus DURATION")
+		}
+	}
+	if d.EndNext {
+		if d.Class == "" {
+			fs.add(Must, "daterange-end-on-next", "4.4.5.1", tag, i, "END-ON-NEXT requires a CLASS")
+		}
+		if d.Duration != 0 || !d.End.IsZero() {
+			fs.add(Must, "daterange-end-on-next", "4.4.5.1", tag, i, "END-ON-NEXT must not be combined with DURATION or END-DATE")
+		}
+	}
+	if d.IsInterstitial() && (d.AssetURI == "") == (d.AssetList == "") {
+		fs.add(Must, "interstitial-asset", "", tag, i, "interstitial needs exactly one of X-ASSET-URI or X-ASSET-LIST")
+	}
+}
+
+func hasprefix(s string, prefix ...string) bool {
+	for _, p := range prefix {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
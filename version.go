@@ -1,5 +1,12 @@
 package hls
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
 /*
 	https://tools.ietf.org/html/draft-pantos-http-live-streaming-23#page-7
 
@@ -19,5 +26,138 @@ package hls
 	that the order of the segments dictates which order they are played in.
 
 	Each segment has a sequence and discontinuity sequence number. Both properties are
-	computed.
+	computed, see Media.Timeline.
 */
+
+// ErrVersion is returned by the encoders when the caller sets a
+// protocol version lower than the one the playlist's features require
+var ErrVersion = errors.New("hls: protocol version too low")
+
+// MinVersion returns the lowest protocol version that is compatible
+// with the features used in the playlist, as specified here:
+//
+// https://datatracker.ietf.org/doc/html/draft-pantos-hls-rfc8216bis#section-8
+func (m Media) MinVersion() int {
+	v, _ := m.minversion()
+	return v
+}
+
+// MinVersion returns the lowest protocol version that is compatible
+// with the features used in the playlist.
+func (m Master) MinVersion() int {
+	v, _ := m.minversion()
+	return v
+}
+
+// feature is the minimum version and the reason for it
+type feature struct {
+	v   int
+	why string
+}
+
+func (f *feature) need(v int, why string) {
+	if v > f.v {
+		f.v, f.why = v, why
+	}
+}
+
+func (m Media) minversion() (int, string) {
+	f := feature{v: 1}
+	f.define(m.Define)
+	if m.IFramesOnly {
+		f.need(4, "EXT-X-I-FRAMES-ONLY")
+	}
+	if m.Control.CanSkipUntil > 0 {
+		f.need(9, "CAN-SKIP-UNTIL")
+	}
+	if m.Control.CanSkipDateRanges {
+		f.need(10, "CAN-SKIP-DATERANGES")
+	}
+	if m.Skip.Segments > 0 {
+		f.need(9, "EXT-X-SKIP")
+	}
+	if len(m.Skip.Removed) > 0 {
+		f.need(10, "RECENTLY-REMOVED-DATERANGES")
+	}
+	for _, v := range m.File {
+		if v.Inf.Duration%time.Second != 0 {
+			f.need(3, "a floating-point EXTINF duration")
+		}
+		if v.Range.V != "" {
+			f.need(4, "EXT-X-BYTERANGE")
+		}
+		if v.Map != (Map{}) {
+			if m.IFramesOnly {
+				f.need(5, "EXT-X-MAP")
+			} else {
+				f.need(6, "EXT-X-MAP without EXT-X-I-FRAMES-ONLY")
+			}
+		}
+		f.key(v.Key...)
+	}
+	return f.v, f.why
+}
+
+func (m Master) minversion() (int, string) {
+	f := feature{v: 1}
+	f.define(m.Define)
+	f.key(m.SessionKey...)
+	for _, v := range m.Media {
+		if strings.HasPrefix(v.Instream, "SERVICE") {
+			f.need(7, "INSTREAM-ID="+v.Instream)
+		}
+	}
+	return f.v, f.why
+}
+
+func (f *feature) define(def []Define) {
+	for _, d := range def {
+		f.need(8, "EXT-X-DEFINE")
+		if d.Query != "" {
+			f.need(11, "QUERYPARAM")
+		}
+	}
+}
+
+func (f *feature) key(k ...Key) {
+	for _, k := range k {
+		if k.IV != "" {
+			f.need(2, "the IV attribute")
+		}
+		if k.Format != "" || k.Versions != "" {
+			f.need(5, "KEYFORMAT")
+		}
+		if strings.HasPrefix(k.Method, "SAMPLE-AES") {
+			f.need(5, "METHOD="+k.Method)
+		}
+	}
+}
+
+// setversion raises m.Version to the minimum version if it's zero
+// or the version m was decoded with, or returns ErrVersion if the
+// caller set it too low
+func (m *Media) setversion() error {
+	v, why := m.minversion()
+	return checkversion(&m.Version, m.declared, v, why)
+}
+
+// setversion is like Media.setversion
+func (m *Master) setversion() error {
+	v, why := m.minversion()
+	return checkversion(&m.Version, m.declared, v, why)
+}
+
+// checkversion checks the version v against min. Playlists in the wild
+// often declare a version lower than their features need, so a version
+// that is unchanged since decoding is raised instead of rejected.
+func checkversion(v *int, declared, min int, why string) error {
+	switch {
+	case *v >= min:
+	case *v == 0 && min == 1:
+	case *v == 0, *v == declared:
+		*v = min
+	default:
+		return fmt.Errorf("%w: version %d, but %s requires version %d", ErrVersion, *v, why, min)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEnded is returned when a segment is added to a window after End
+	ErrEnded = errors.New("hls: playlist has ended")
+
+	// ErrTarget is returned when a segment is longer than the target
+	// duration, which must not change during the life of the playlist
+	ErrTarget = errors.New("hls: segment exceeds the target duration")
+)
+
+// Window is a live media playlist maintained by an origin server. Segments
+// are appended to the end of the window and evicted from the start, and
+// the window keeps the media and discontinuity sequence numbers in step.
+// It's safe for concurrent use.
+//
+// EVENT playlists are append-only, so nothing is evicted from them.
+type Window struct {
+	mu     sync.Mutex
+	m      Media
+	max    int
+	maxdur time.Duration
+}
+
+// NewWindow returns a window with header h that holds at most max segments
+// or maxdur worth of segments. A zero limit is ignored. The window always
+// holds at least one segment. If h.Target is zero, it's set from the first
+// segment appended.
+func NewWindow(h MediaHeader, max int, maxdur time.Duration) *Window {
+	h.M3U = true
+	return &Window{m: Media{MediaHeader: h}, max: max, maxdur: maxdur}
+}
+
+// Append adds the completed segment f to the window and evicts old
+// segments. If f has no parts, it takes the parts added with AppendPart
+// since the last segment. It returns ErrTarget, and doesn't add f, if the
+// rounded duration of f exceeds the target duration.
+//
+// Each segment carries its own EXT-X-MAP and EXT-X-KEY state, just as
+// it does in a decoded playlist, and the encoder only emits the tags
+// where they change.
+func (w *Window) Append(f File) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m.End {
+		return ErrEnded
+	}
+	d := f.Inf.Duration.Round(time.Second)
+	switch {
+	case w.m.Target == 0 && len(w.m.File) == 0:
+		w.m.Target = d
+	case d > w.m.Target:
+		return fmt.Errorf("%w: %s is longer than %s", ErrTarget, f.Inf.Duration, w.m.Target)
+	}
+	if len(f.Part) == 0 {
+		f.Part = w.m.Part
+	}
+	w.m.Part = nil
+	w.m.File = append(w.m.File, f)
+	w.evict()
+	return nil
+}
+
+// AppendPart adds a partial segment of the segment currently being
+// produced. It's moved into that segment by Append.
+func (w *Window) AppendPart(p Part) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m.End {
+		return ErrEnded
+	}
+	w.m.Part = append(w.m.Part, p)
+	return nil
+}
+
+// End appends EXT-X-ENDLIST to the playlist. An EVENT playlist
+// becomes a VOD playlist, since it can no longer change. Parts of an
+// unfinished segment are discarded.
+func (w *Window) End() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m.Type == Event {
+		w.m.Type = Vod
+	}
+	w.m.End = true
+	w.m.Part, w.m.Hint = nil, nil
+}
+
+// Media returns a snapshot of the playlist
+func (w *Window) Media() Media {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.m
+	m.File = append([]File{}, m.File...)
+	m.Part = append([]Part{}, m.Part...)
+	return m
+}
+
+// evict removes segments from the start of the window until it's within
+// its limits. The discontinuity sequence is incremented for every evicted
+// EXT-X-DISCONTINUITY tag.
+func (w *Window) evict() {
+	if w.m.Type == Event {
+		return
+	}
+	dur := Runtime(w.m.File...)
+	for len(w.m.File) > 1 && (w.max > 0 && len(w.m.File) > w.max || w.maxdur > 0 && dur > w.maxdur) {
+		f := w.m.File[0]
+		dur -= f.Inf.Duration
+		if f.Discontinuous {
+			w.m.Discontinuity++
+		}
+		w.m.Sequence++
+		w.m.File = w.m.File[1:]
+	}
+}